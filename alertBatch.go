@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// batchOptions_t collects the alerts of /alert for Window, and sends one message per group of alerts
+// having the same GroupBy labels and targets, across the requests.
+// Set by the "batch" section of WEBHOOK_CONFIG file, routes can override the options.
+type batchOptions_t struct {
+	Disabled bool       `json:"disabled,omitempty"`
+	Window   duration_t `json:"window,omitempty"`   // 0 - no batching
+	GroupBy  []string   `json:"groupBy,omitempty"`  // labels, ["alertname"] by default
+	MaxLines int        `json:"maxLines,omitempty"` // alert lines in the message, 20 by default
+}
+
+func (b batchOptions_t) merge(o *batchOptions_t) batchOptions_t {
+	if o == nil {
+		return b
+	}
+	b.Disabled = o.Disabled
+	if o.Window > 0 {
+		b.Window = o.Window
+	}
+	if len(o.GroupBy) > 0 {
+		b.GroupBy = o.GroupBy
+	}
+	if o.MaxLines > 0 {
+		b.MaxLines = o.MaxLines
+	}
+	return b
+}
+
+// batchOptions returns the options of the first matching route having them, over the global ones.
+// nil - batching is not configured or disabled.
+func (c *config_t) batchOptions(labels map[string]string) *batchOptions_t {
+
+	if c.Batch == nil {
+		return nil
+	}
+	opts := batchOptions_t{GroupBy: []string{"alertname"}, MaxLines: 20}.merge(c.Batch)
+	for _, r := range c.Routes {
+		if r.Batch != nil && r.matches(labels) {
+			opts = opts.merge(r.Batch)
+			break
+		}
+	}
+	if opts.Disabled || opts.Window <= 0 {
+		return nil
+	}
+	return &opts
+}
+
+// batch_t is the group of alerts waiting to be sent.
+type batch_t struct {
+	groupLabels map[string]string
+	targets     []target_t
+	opts        batchOptions_t
+	items       []batchItem
+	timer       *time.Timer
+}
+
+type batchItem struct {
+	n     *notification
+	dedup dedupOptions_t
+}
+
+// batcher_t keeps the open batches, flush is called when the window of the batch ends.
+type batcher_t struct {
+	mu      sync.Mutex
+	batches map[string]*batch_t
+	flush   func(b *batch_t)
+}
+
+func newBatcher(flush func(b *batch_t)) *batcher_t {
+	return &batcher_t{batches: make(map[string]*batch_t), flush: flush}
+}
+
+// add puts the notification of the alert into its batch, the first one opens the batch for the window.
+func (bt *batcher_t) add(n *notification, targets []target_t, opts *batchOptions_t, dedup dedupOptions_t) {
+
+	groupLabels := make(map[string]string)
+	var key []string
+	for _, l := range opts.GroupBy {
+		groupLabels[l] = n.alert.Labels[l]
+		key = append(key, l+"="+n.alert.Labels[l])
+	}
+	for _, t := range targets {
+		key = append(key, t.String())
+	}
+	k := strings.Join(key, "\x00")
+
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	b, ok := bt.batches[k]
+	if !ok {
+		b = &batch_t{groupLabels: groupLabels, targets: targets, opts: *opts}
+		bt.batches[k] = b
+		b.timer = time.AfterFunc(time.Duration(opts.Window), func() {
+			bt.mu.Lock()
+			if bt.batches[k] != b {
+				bt.mu.Unlock()
+				return // flushed by flushAll
+			}
+			delete(bt.batches, k)
+			bt.mu.Unlock()
+			bt.flush(b)
+		})
+	}
+	for i, it := range b.items {
+		if len(n.fingerprint) > 0 && it.n.fingerprint == n.fingerprint {
+			b.items[i] = batchItem{n: n, dedup: dedup} // repeated by Grafana, the last one wins
+			return
+		}
+	}
+	b.items = append(b.items, batchItem{n: n, dedup: dedup})
+}
+
+// flushAll sends the open batches now, on shutdown.
+func (bt *batcher_t) flushAll() {
+
+	bt.mu.Lock()
+	var batches []*batch_t
+	for k, b := range bt.batches {
+		b.timer.Stop()
+		batches = append(batches, b)
+		delete(bt.batches, k)
+	}
+	bt.mu.Unlock()
+
+	for _, b := range batches {
+		bt.flush(b)
+	}
+}
+
+// fingerprints returns the fingerprints of the batch alerts, for the logs.
+func (b *batch_t) fingerprints() []string {
+	var fps []string
+	for _, it := range b.items {
+		fps = append(fps, it.n.fingerprint)
+	}
+	return fps
+}
+
+// flushBatch sends the batch: the alert as it is if it is alone, or one combined message.
+func (a *App) flushBatch(b *batch_t) {
+
+	n := b.items[0].n
+	if len(b.items) == 1 {
+		image, err := a.getImage(a.ctx, n.alert)
+		if err != nil {
+			slog.Error("Alert-Webhook, batch", "err", err)
+		}
+		n.image = image
+	} else {
+		var alerts []*AlertBody
+		for _, it := range b.items {
+			alerts = append(alerts, it.n.alert)
+		}
+		n = b.notification()
+		n.mentions, n.dm = a.config.mentions(alerts)
+		a.config.applySeverity(n, a.config.mostSevere(alerts))
+		n.images = a.getImages(a.ctx, alerts)
+		if len(n.images) > 0 {
+			n.image = n.images[0]
+		}
+	}
+	// Registered even if some targets failed: the alerts are escalated and re-notified anyway
+	sent, err := a.deliverTargets(a.ctx, n, b.targets)
+	for _, it := range b.items {
+		a.register(it.n, b.targets, len(sent) > 0, it.dedup)
+	}
+	if err != nil {
+		// Grafana has got the response already, it will not repeat the alerts
+		slog.Error("Alert-Webhook, batch send error", "alerts", len(b.items), "fingerprints", b.fingerprints(), "err", err)
+		return
+	}
+	slog.Info("Alert-Webhook, batch sent success", "alerts", len(b.items))
+}
+
+// notification builds the combined message: count header, group labels, a line per alert, "+N more".
+func (b *batch_t) notification() *notification {
+
+	firing := 0
+	for _, it := range b.items {
+		if it.n.status == "firing" {
+			firing++
+		}
+	}
+	status := "resolved"
+	if firing > 0 {
+		status = "firing"
+	}
+
+	var group []string
+	for _, l := range b.opts.GroupBy {
+		if v := b.groupLabels[l]; len(v) > 0 {
+			group = append(group, l+"="+v)
+		}
+	}
+	title := strings.Join(group, ", ")
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "****** %d alerts ******\n", len(b.items))
+	fmt.Fprintf(&sb, "Firing: %d, Resolved: %d\n", firing, len(b.items)-firing)
+	if len(title) > 0 {
+		sb.WriteString(title + "\n")
+	}
+	sb.WriteString("**********************\n")
+	for i, it := range b.items {
+		if i == b.opts.MaxLines {
+			fmt.Fprintf(&sb, "+%d more\n", len(b.items)-i)
+			break
+		}
+		sb.WriteString(b.line(it.n) + "\n")
+	}
+
+	return &notification{
+		alert:   b.items[0].n.alert,
+		body:    b.items[0].n.body,
+		status:  status,
+		subject: fmt.Sprintf("[%s:%d] %s", strings.ToUpper(status), len(b.items), title),
+		msg:     strings.TrimSuffix(sb.String(), "\n"),
+	}
+}
+
+// line is the alert in the combined message: status, name, labels out of the group, summary.
+func (b *batch_t) line(n *notification) string {
+	return alertLine(n, b.groupLabels)
+}
+
+// alertLine is the alert in one line: status, name, labels except the skipped ones, summary.
+func alertLine(n *notification, skip map[string]string) string {
+
+	var labels []string
+	for k, v := range n.alert.Labels {
+		if _, ok := skip[k]; ok || k == "alertname" || k == "chatID" || k == "email" {
+			continue
+		}
+		labels = append(labels, k+"="+v)
+	}
+	sort.Strings(labels)
+
+	line := fmt.Sprintf("[%s] %s", strings.ToUpper(n.status), n.alert.Labels["alertname"])
+	if len(labels) > 0 {
+		line += " {" + strings.Join(labels, ", ") + "}"
+	}
+	if summary, ok := n.alert.Annotations["summary"].(string); ok && len(summary) > 0 {
+		line += " - " + summary
+	}
+	return line
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBatcher(t *testing.T) {
+	flushed := make(chan *batch_t, 10)
+	bt := newBatcher(func(b *batch_t) { flushed <- b })
+	opts := &batchOptions_t{Window: duration_t(50 * time.Millisecond), GroupBy: []string{"alertname"}, MaxLines: 3}
+	ops := []target_t{{"telegram", "-100"}}
+
+	for i := 0; i < 5; i++ {
+		alert := &AlertBody{
+			Labels:      map[string]string{"alertname": "CPU", "instance": fmt.Sprintf("web%d", i)},
+			Annotations: map[string]interface{}{"summary": "CPU is high"},
+		}
+		bt.add(&notification{alert: alert, fingerprint: fmt.Sprintf("fp%d", i), status: "firing"}, ops, opts, dedupOptions_t{})
+	}
+	// Repeat of fp0, other group, other targets
+	bt.add(&notification{alert: &AlertBody{Labels: map[string]string{"alertname": "CPU"}}, fingerprint: "fp0", status: "resolved"}, ops, opts, dedupOptions_t{})
+	bt.add(&notification{alert: &AlertBody{Labels: map[string]string{"alertname": "Disk"}}, fingerprint: "fp9", status: "firing"}, ops, opts, dedupOptions_t{})
+	bt.add(&notification{alert: &AlertBody{Labels: map[string]string{"alertname": "CPU"}}, fingerprint: "fp8", status: "firing"}, []target_t{{"email", "ops@example.com"}}, opts, dedupOptions_t{})
+
+	sizes := map[string]int{}
+	var cpu *batch_t
+	for i := 0; i < 3; i++ {
+		select {
+		case b := <-flushed:
+			key := b.groupLabels["alertname"] + " " + b.targets[0].kind
+			sizes[key] = len(b.items)
+			if key == "CPU telegram" {
+				cpu = b
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Batches are not flushed: %v", sizes)
+		}
+	}
+	if sizes["CPU telegram"] != 5 || sizes["Disk telegram"] != 1 || sizes["CPU email"] != 1 {
+		t.Fatalf("Unexpected batches %v", sizes)
+	}
+
+	n := cpu.notification()
+	if n.subject != "[FIRING:5] alertname=CPU" {
+		t.Errorf("Subject %q", n.subject)
+	}
+	for _, s := range []string{"****** 5 alerts ******", "Firing: 4, Resolved: 1", "[RESOLVED] CPU\n", "[FIRING] CPU {instance=web1} - CPU is high", "+2 more"} {
+		if !strings.Contains(n.msg, s) {
+			t.Errorf("Message does not contain %q:\n%s", s, n.msg)
+		}
+	}
+}
+
+func TestBatcherFlushAll(t *testing.T) {
+	flushed := make(chan *batch_t, 10)
+	bt := newBatcher(func(b *batch_t) { flushed <- b })
+	opts := &batchOptions_t{Window: duration_t(100 * time.Millisecond), GroupBy: []string{"alertname"}}
+
+	bt.add(&notification{alert: &AlertBody{Labels: map[string]string{"alertname": "CPU"}}, fingerprint: "fp1"}, nil, opts, dedupOptions_t{})
+	bt.flushAll()
+	if len(flushed) != 1 {
+		t.Fatalf("Flushed %d batches", len(flushed))
+	}
+	time.Sleep(200 * time.Millisecond)
+	if len(flushed) != 1 {
+		t.Error("Batch is flushed again by the timer")
+	}
+}
+
+func TestFlushBatchSeverity(t *testing.T) {
+	app, f := testTelegramApp(t)
+	app.notifiers = map[string]notifier{"telegram": &telegramNotifier_t{a: app}}
+	app.states = newAlertStore()
+	app.config.Severity = map[string]*severity_t{"critical": {Emoji: "🔥"}, "info": {Silent: true}}
+
+	b := &batch_t{groupLabels: map[string]string{"alertname": "CPU"}, targets: []target_t{{"telegram", "-100"}}, opts: batchOptions_t{MaxLines: 20}}
+	for i, level := range []string{"info", "critical", "info"} {
+		alert := &AlertBody{Status: "firing", Labels: map[string]string{"alertname": "CPU", "severity": level}}
+		b.items = append(b.items, batchItem{n: &notification{alert: alert, fingerprint: fmt.Sprintf("fp%d", i), status: "firing"}})
+	}
+	app.flushBatch(b)
+
+	if len(f.calls) != 1 {
+		t.Fatalf("Calls %v", f.methods())
+	}
+	if text := f.calls[0].fields["text"]; !strings.HasPrefix(text, "🔥 CRITICAL\n") || f.calls[0].fields["disable_notification"] == "true" {
+		t.Errorf("Batch message %v", f.calls[0].fields)
+	}
+}
+
+func TestFlushBatchSendError(t *testing.T) {
+	app, f := testTelegramApp(t)
+	app.notifiers = map[string]notifier{"telegram": &telegramNotifier_t{a: app}}
+	app.states = newAlertStore()
+	f.fail["sendMessage"] = "chat not found"
+
+	b := &batch_t{groupLabels: map[string]string{"alertname": "CPU"}, targets: []target_t{{"telegram", "-100"}}, opts: batchOptions_t{MaxLines: 20}}
+	for i := 0; i < 2; i++ {
+		alert := &AlertBody{Status: "firing", Labels: map[string]string{"alertname": "CPU"}}
+		b.items = append(b.items, batchItem{n: &notification{alert: alert, fingerprint: fmt.Sprintf("fp%d", i), status: "firing"}})
+	}
+	app.flushBatch(b)
+	if list := app.states.list(); len(list) != 2 {
+		t.Errorf("Alerts table %+v", list)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// escalation_t is the policy for the firing alerts neither acknowledged nor resolved: each step re-sends the alert
+// to its targets After the alert started firing (/alert only).
+// Set by the "escalations" section of WEBHOOK_CONFIG file by name, routes refer to the policy by the name,
+// "default" one is for the alerts of the routes without it.
+type escalation_t struct {
+	Steps []*escalationStep_t `json:"steps"`
+
+	name string
+}
+
+type escalationStep_t struct {
+	After   duration_t `json:"after"`
+	Targets []string   `json:"targets"`
+
+	targets []target_t
+}
+
+func (e *escalation_t) init(name string) error {
+
+	e.name = name
+	if len(e.Steps) == 0 {
+		return fmt.Errorf("no steps")
+	}
+	var after duration_t
+	for i, st := range e.Steps {
+		if st.After <= after {
+			return fmt.Errorf("step %d: after should be more than %s", i+1, time.Duration(after))
+		}
+		after = st.After
+		if len(st.Targets) == 0 {
+			return fmt.Errorf("step %d: no targets", i+1)
+		}
+		st.targets = nil
+		for _, t := range st.Targets {
+			tt, err := parseTarget(t)
+			if err != nil {
+				return fmt.Errorf("step %d: %w", i+1, err)
+			}
+			st.targets = append(st.targets, tt)
+		}
+	}
+	return nil
+}
+
+// escalationPolicy returns the policy of the first matching route having it, or the "default" one.
+// "none" of the route switches the escalation off.
+func (c *config_t) escalationPolicy(labels map[string]string) *escalation_t {
+	for _, r := range c.Routes {
+		if len(r.Escalation) > 0 && r.matches(labels) {
+			return c.Escalations[r.Escalation] // nil for "none"
+		}
+	}
+	return c.Escalations["default"]
+}
+
+// escalationNotice is the escalated notification and the targets of the step.
+type escalationNotice struct {
+	n       *notification
+	targets []target_t
+}
+
+// escalationDue returns the escalation steps due, marking them as done.
+func (s *alertStore_t) escalationDue(now time.Time) []escalationNotice {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []escalationNotice
+	for fp, st := range s.states {
+		if st.status != "firing" || st.acked || st.escalation == nil || st.step >= len(st.escalation.Steps) {
+			continue
+		}
+		if f, ok := s.flaps[fp]; ok && f.flapping {
+			continue
+		}
+		step := st.escalation.Steps[st.step]
+		if now.Sub(st.since) < time.Duration(step.After) {
+			continue
+		}
+		st.step++
+		st.escalated = dedupTargets(append(st.escalated, step.targets...))
+
+		n := *st.n
+		n.pin = false // not in the chats of the escalation
+		n.dm = false  // the users got the first message directly
+		n.subject = strings.Replace(n.subject, "[FIRING]", "[FIRING, ESCALATED]", 1)
+		n.msg = fmt.Sprintf("[ESCALATION %d/%d] Not acknowledged for %s\n%s",
+			st.step, len(st.escalation.Steps), roundDuration(now.Sub(st.since)), n.msg)
+		due = append(due, escalationNotice{n: &n, targets: step.targets})
+	}
+	return due
+}
+
+// ack acknowledges the firing alert, it is not escalated and re-notified anymore.
+// Returns the notice of the acknowledgement to all the targets notified.
+func (s *alertStore_t) ack(fingerprint string, by string) (*escalationNotice, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.states[fingerprint]
+	if !ok {
+		return nil, errNotFound
+	}
+	if st.status != "firing" {
+		return nil, fmt.Errorf("alert is %s", st.status)
+	}
+	if st.acked {
+		return nil, fmt.Errorf("alert is acknowledged by %s at %s", st.ackedBy, st.ackedAt.Format(time.TimeOnly))
+	}
+	st.acked = true
+	st.ackedBy = by
+	st.ackedAt = time.Now()
+
+	name := ""
+	if st.n.alert != nil {
+		name = st.n.alert.Labels["alertname"]
+	}
+	// No fingerprint: the notice is not the message of the alert, Matrix does not edit it on resolve
+	n := &notification{
+		alert:   st.n.alert,
+		body:    st.n.body,
+		status:  st.status,
+		subject: "[ACK] " + name,
+		msg:     fmt.Sprintf("****** Acknowledged ******\n%s is acknowledged by %s", name, by),
+	}
+	targets := dedupTargets(append(append([]target_t{}, st.targets...), st.escalated...))
+	return &escalationNotice{n: n, targets: targets}, nil
+}
+
+// alertStatus is the alert in GET /alerts.
+type alertStatus struct {
+	Fingerprint string     `json:"fingerprint"`
+	Name        string     `json:"alertname,omitempty"`
+	Status      string     `json:"status"`
+	Since       time.Time  `json:"since"`
+	LastSent    time.Time  `json:"lastSent"`
+	Escalation  string     `json:"escalation,omitempty"`
+	Step        int        `json:"escalationStep,omitempty"` // steps done
+	Acked       bool       `json:"acked"`
+	AckedBy     string     `json:"ackedBy,omitempty"`
+	AckedAt     *time.Time `json:"ackedAt,omitempty"`
+}
+
+// list returns the alerts table, the oldest first.
+func (s *alertStore_t) list() []alertStatus {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := []alertStatus{}
+	for fp, st := range s.states {
+		as := alertStatus{
+			Fingerprint: fp,
+			Status:      st.status,
+			Since:       st.since,
+			LastSent:    st.lastSent,
+			Step:        st.step,
+			Acked:       st.acked,
+			AckedBy:     st.ackedBy,
+		}
+		if st.n != nil && st.n.alert != nil {
+			as.Name = st.n.alert.Labels["alertname"]
+		}
+		if st.escalation != nil {
+			as.Escalation = st.escalation.name
+		}
+		if st.acked {
+			as.AckedAt = &st.ackedAt
+		}
+		list = append(list, as)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Since.Before(list[j].Since) })
+	return list
+}
+
+// register records the alert sent to the targets in the alerts table, with its escalation policy.
+// delivered is false if all the targets failed.
+func (a *App) register(n *notification, targets []target_t, delivered bool, dedup dedupOptions_t) {
+	a.states.sent(n, targets, delivered, dedup, a.config.escalationPolicy(n.alert.Labels))
+}
+
+// Alerts lists the alerts table.
+func (a *App) Alerts(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, a.states.list())
+}
+
+// AlertAck acknowledges the alert by its fingerprint, {"by": "name"} body is optional.
+func (a *App) AlertAck(w http.ResponseWriter, r *http.Request) {
+
+	if !a.authorized(w, r) {
+		return
+	}
+	var req struct {
+		By string `json:"by"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithJSON(w, http.StatusBadRequest, map[string]string{"result": "error", "message": "Invalid JSON Format"})
+			return
+		}
+	}
+	if len(req.By) == 0 {
+		req.By = "API"
+	}
+	fp := mux.Vars(r)["fingerprint"]
+	notice, err := a.states.ack(fp, req.By)
+	if err != nil {
+		code := http.StatusConflict
+		if err == errNotFound {
+			code = http.StatusNotFound
+		}
+		respondWithJSON(w, code, map[string]string{"result": "error", "message": err.Error()})
+		return
+	}
+	slog.Info("Alert-Webhook, acknowledged", "fingerprint", fp, "by", req.By)
+	if err := a.deliver(notice.n, notice.targets); err != nil {
+		slog.Error("Alert-Webhook, acknowledgement notice error", "fingerprint", fp, "err", err)
+	}
+	respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEscalation(t *testing.T) {
+	policy := &escalation_t{Steps: []*escalationStep_t{
+		{After: duration_t(15 * time.Minute), Targets: []string{"telegram:-200"}},
+		{After: duration_t(time.Hour), Targets: []string{"telegram:300", "email:boss@example.com"}},
+	}}
+	if err := policy.init("db"); err != nil {
+		t.Fatal(err)
+	}
+	s := newAlertStore()
+	alert := &AlertBody{Labels: map[string]string{"alertname": "Replication"}}
+	ops := []target_t{{"telegram", "-100"}}
+	n := &notification{alert: alert, fingerprint: "fp1", status: "firing", subject: "[FIRING] Replication", msg: "Lag", pin: true, dm: true}
+	s.sent(n, ops, true, dedupOptions_t{}, policy)
+	// Grafana repeat does not restart the escalation
+	s.states["fp1"].since = s.states["fp1"].since.Add(-20 * time.Minute)
+	s.sent(n, ops, true, dedupOptions_t{}, policy)
+
+	due := s.escalationDue(time.Now())
+	if len(due) != 1 || due[0].targets[0].dest != "-200" {
+		t.Fatalf("Expected step 1, got %+v", due)
+	}
+	if due[0].n.subject != "[FIRING, ESCALATED] Replication" || !strings.HasPrefix(due[0].n.msg, "[ESCALATION 1/2] Not acknowledged for 20m") {
+		t.Errorf("Escalation %q\n%s", due[0].n.subject, due[0].n.msg)
+	}
+	if due[0].n.pin || !n.pin {
+		t.Errorf("Escalation is pinned")
+	}
+	if due[0].n.dm {
+		t.Errorf("Escalation is sent directly to the mentioned users")
+	}
+	if due := s.escalationDue(time.Now()); len(due) != 0 {
+		t.Errorf("Step 1 is repeated")
+	}
+
+	notice, err := s.ack("fp1", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notice.targets) != 2 || !strings.Contains(notice.n.msg, "Replication is acknowledged by alice") || notice.n.fingerprint != "" {
+		t.Errorf("Ack notice %+v %q", notice.targets, notice.n.msg)
+	}
+	if due := s.escalationDue(time.Now().Add(2 * time.Hour)); len(due) != 0 {
+		t.Errorf("Acknowledged alert is escalated")
+	}
+	if _, err := s.ack("fp1", "bob"); err == nil {
+		t.Errorf("Acknowledged twice")
+	}
+
+	// Resolved and firing again: new escalation, not acknowledged
+	s.sent(&notification{alert: alert, fingerprint: "fp1", status: "resolved"}, ops, true, dedupOptions_t{}, nil)
+	if _, err := s.ack("fp1", "bob"); err == nil {
+		t.Errorf("Resolved alert is acknowledged")
+	}
+	s.sent(n, ops, true, dedupOptions_t{}, policy)
+	if list := s.list(); len(list) != 1 || list[0].Acked || list[0].Escalation != "db" || list[0].Step != 0 {
+		t.Errorf("Alerts table %+v", list)
+	}
+	if _, err := s.ack("fp2", "bob"); err != errNotFound {
+		t.Errorf("Unknown alert: %v", err)
+	}
+}
+
+func TestEscalationConfig(t *testing.T) {
+	c := &config_t{
+		Escalations: map[string]*escalation_t{
+			"default": {Steps: []*escalationStep_t{{After: duration_t(time.Hour), Targets: []string{"telegram:1"}}}},
+			"db":      {Steps: []*escalationStep_t{{After: duration_t(time.Minute), Targets: []string{"telegram:2"}}}},
+		},
+		Routes: []*route_t{
+			{Match: map[string]string{"team": "db"}, Escalation: "db"},
+			{Match: map[string]string{"team": "dev"}, Escalation: "none"},
+		},
+	}
+	if c.escalationPolicy(map[string]string{"team": "db"}) != c.Escalations["db"] {
+		t.Error("Route policy is not used")
+	}
+	if c.escalationPolicy(map[string]string{"team": "web"}) != c.Escalations["default"] {
+		t.Error("Default policy is not used")
+	}
+	if c.escalationPolicy(map[string]string{"team": "dev"}) != nil {
+		t.Error("Escalation is not off")
+	}
+
+	for _, bad := range []*escalation_t{
+		{},
+		{Steps: []*escalationStep_t{{After: duration_t(time.Hour)}}},
+		{Steps: []*escalationStep_t{{After: duration_t(time.Hour), Targets: []string{"telegram:1"}}, {After: duration_t(time.Minute), Targets: []string{"telegram:1"}}}},
+		{Steps: []*escalationStep_t{{After: duration_t(time.Hour), Targets: []string{"chat"}}}},
+	} {
+		if err := bad.init("bad"); err == nil {
+			t.Errorf("%+v is accepted", bad)
+		}
+	}
+}
+
+func TestAlertAckAPI(t *testing.T) {
+	alert := &AlertBody{Labels: map[string]string{"alertname": "AckTest"}}
+	a.states.sent(&notification{alert: alert, fingerprint: "ack-test", status: "firing"}, nil, true, dedupOptions_t{}, nil)
+	defer delete(a.states.states, "ack-test")
+
+	req := apiRequest("POST", "/alerts/ack-test/ack", strings.NewReader(`{"by":"alice"}`))
+	checkResponseCode(t, http.StatusOK, executeRequest(req).Code)
+	req = apiRequest("POST", "/alerts/ack-test/ack", nil)
+	checkResponseCode(t, http.StatusConflict, executeRequest(req).Code)
+	req = apiRequest("POST", "/alerts/unknown/ack", nil)
+	checkResponseCode(t, http.StatusNotFound, executeRequest(req).Code)
+
+	req, _ = http.NewRequest("GET", "/alerts", nil)
+	rr := executeRequest(req)
+	var list []alertStatus
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	found := false
+	for _, as := range list {
+		if as.Fingerprint == "ack-test" {
+			found = as.Acked && as.AckedBy == "alice" && as.Name == "AckTest"
+		}
+	}
+	if !found {
+		t.Errorf("Alerts %s", rr.Body.String())
+	}
+}
+
+func TestAlertSendErrorRegistered(t *testing.T) {
+	app, f := testTelegramApp(t)
+	app.notifiers = map[string]notifier{"telegram": &telegramNotifier_t{a: app}}
+	app.states = newAlertStore()
+	app.maintenance = newMaintenanceStore(nil)
+	app.batches = newBatcher(app.flushBatch)
+	app.config.Routes = []*route_t{
+		{Match: map[string]string{"team": "db"}, Targets: []string{"telegram:-100", "email:dba@example.com"}},
+	}
+	f.fail["sendMessage"] = "chat not found"
+
+	body := `{"status": "firing", "alerts": [
+		{"status": "firing", "fingerprint": "fp1", "labels": {"alertname": "Lag", "team": "db"}},
+		{"status": "firing", "fingerprint": "fp2", "labels": {"alertname": "Disk", "team": "db"}}]}`
+	rr := httptest.NewRecorder()
+	app.Alert(rr, httptest.NewRequest("POST", "/alert", strings.NewReader(body)))
+
+	// Both alerts are tried, reported and registered for the escalation
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "fp1") || !strings.Contains(rr.Body.String(), "fp2") {
+		t.Errorf("Response %d %s", rr.Code, rr.Body)
+	}
+	if len(f.calls) != 2 {
+		t.Errorf("Calls %v", f.methods())
+	}
+	if list := app.states.list(); len(list) != 2 {
+		t.Errorf("Alerts table %+v", list)
+	}
+}
+
+func TestMutedResolveEndsEscalation(t *testing.T) {
+	app, _ := testTelegramApp(t)
+	app.notifiers = map[string]notifier{"telegram": &telegramNotifier_t{a: app}}
+	app.states = newAlertStore()
+	app.maintenance = newMaintenanceStore(nil)
+	app.batches = newBatcher(app.flushBatch)
+	app.config.Routes = []*route_t{{Match: map[string]string{"team": "db"}, Targets: []string{"telegram:-100"}}}
+	policy := &escalation_t{Steps: []*escalationStep_t{{After: duration_t(time.Minute), Targets: []string{"telegram:-200"}}}}
+	if err := policy.init("default"); err != nil {
+		t.Fatal(err)
+	}
+	app.config.Escalations = map[string]*escalation_t{"default": policy}
+
+	alert := &AlertBody{Labels: map[string]string{"alertname": "Lag", "team": "db"}}
+	app.register(&notification{alert: alert, fingerprint: "fp1", status: "firing"}, []target_t{{"telegram", "-100"}}, true, dedupOptions_t{})
+	start := time.Now().Add(-time.Minute)
+	end := time.Now().Add(time.Hour)
+	if err := app.maintenance.add(&maintenance_t{Name: "upgrade", Match: map[string]string{"team": "db"}, Start: &start, End: &end}); err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"status": "resolved", "alerts": [{"status": "resolved", "fingerprint": "fp1", "labels": {"alertname": "Lag", "team": "db"}}]}`
+	app.Alert(httptest.NewRecorder(), httptest.NewRequest("POST", "/alert", strings.NewReader(body)))
+	if due := app.states.escalationDue(time.Now().Add(time.Hour)); len(due) != 0 {
+		t.Errorf("Resolved alert is escalated")
+	}
+	if list := app.states.list(); len(list) != 0 {
+		t.Errorf("Alerts table %+v", list)
+	}
+}
+
+func TestConfigTargets(t *testing.T) {
+	for _, conf := range []string{
+		`{"escalations": {"default": {"steps": [{"after": "1h", "targets": ["on-call:typo"]}]}}}`,
+		`{"severity": {"critical": {"targets": ["on-call:typo"]}}}`,
+		`{"routes": [{"targets": ["on-call:typo"]}]}`,
+	} {
+		file := filepath.Join(t.TempDir(), "config.json")
+		os.WriteFile(file, []byte(conf), 0o600)
+		if _, err := loadConfig(file); err == nil || !strings.Contains(err.Error(), `no oncall schedule "typo"`) {
+			t.Errorf("%s: %v", conf, err)
+		}
+	}
+
+	c := &config_t{Escalations: map[string]*escalation_t{
+		"default": {Steps: []*escalationStep_t{{After: duration_t(time.Hour), Targets: []string{"email:boss@example.com"}}}},
+	}}
+	if err := c.checkTargets(map[string]notifier{"telegram": nil}); err == nil {
+		t.Error("Target of the notifier not configured is accepted")
+	}
+	if err := c.checkTargets(map[string]notifier{"email": nil}); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// flapOptions_t detects flapping alerts (/alert only): Threshold transitions firing <-> resolved inside Window.
+// While the alert is flapping, its notifications are collapsed into a digest sent every Digest,
+// a summary is sent when there were no transitions for Stable.
+// Set by the "flap" section of WEBHOOK_CONFIG file, routes can override the options.
+type flapOptions_t struct {
+	Threshold int        `json:"threshold,omitempty"` // 0 - no flap detection
+	Window    duration_t `json:"window,omitempty"`    // 30m by default
+	Digest    duration_t `json:"digest,omitempty"`    // 10m by default
+	Stable    duration_t `json:"stable,omitempty"`    // 15m by default
+}
+
+func (f flapOptions_t) merge(o *flapOptions_t) flapOptions_t {
+	if o == nil {
+		return f
+	}
+	if o.Threshold != 0 {
+		f.Threshold = o.Threshold
+	}
+	if o.Window > 0 {
+		f.Window = o.Window
+	}
+	if o.Digest > 0 {
+		f.Digest = o.Digest
+	}
+	if o.Stable > 0 {
+		f.Stable = o.Stable
+	}
+	return f
+}
+
+// flapOptions returns the options of the first matching route having them, over the global ones.
+// Threshold < 0 of the route switches the detection off.
+func (c *config_t) flapOptions(labels map[string]string) flapOptions_t {
+
+	opts := flapOptions_t{
+		Window: duration_t(30 * time.Minute),
+		Digest: duration_t(10 * time.Minute),
+		Stable: duration_t(15 * time.Minute),
+	}.merge(c.Flap)
+	for _, r := range c.Routes {
+		if r.Flap != nil && r.matches(labels) {
+			opts = opts.merge(r.Flap)
+			break
+		}
+	}
+	return opts
+}
+
+// flapState_t is the transitions history of the alert.
+type flapState_t struct {
+	name        string
+	status      string // last status from Grafana
+	lastSeen    time.Time
+	transitions []time.Time // inside the window
+
+	flapping   bool
+	since      time.Time // flapping start
+	count      int       // transitions since the start
+	digested   int       // count at the last digest
+	lastDigest time.Time
+	opts       flapOptions_t
+	n          *notification // last notification, for its targets and links
+	targets    []target_t
+}
+
+// transition records the status of the alert. Returns true if the alert is flapping, its notification is collapsed,
+// and the notice to send if it has just started flapping.
+func (s *alertStore_t) transition(n *notification, name string, targets []target_t, opts flapOptions_t) (bool, *flapNotice) {
+
+	if opts.Threshold <= 0 || len(n.fingerprint) == 0 {
+		return false, nil
+	}
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.flaps[n.fingerprint]
+	if !ok {
+		f = &flapState_t{name: name}
+		s.flaps[n.fingerprint] = f
+	}
+	if len(f.status) > 0 && f.status != n.status {
+		f.transitions = append(f.transitions, now)
+		if f.flapping {
+			f.count++
+		}
+	}
+	f.status = n.status
+	f.lastSeen = now
+	f.opts = opts
+	f.n = n
+	f.targets = targets
+
+	// Sliding window
+	from := now.Add(-time.Duration(opts.Window))
+	for len(f.transitions) > 0 && f.transitions[0].Before(from) {
+		f.transitions = f.transitions[1:]
+	}
+	if f.flapping {
+		return true, nil
+	}
+	if len(f.transitions) < opts.Threshold {
+		return false, nil
+	}
+	f.flapping = true
+	f.since = f.transitions[0]
+	f.count = len(f.transitions)
+	f.digested = f.count
+	f.lastDigest = now
+	notice := f.notice(fmt.Sprintf("%s is flapping: %d transitions in %s, notifications are collapsed into a digest every %s",
+		name, f.count, roundDuration(now.Sub(f.since)), time.Duration(opts.Digest)))
+	return true, &notice
+}
+
+// flapNotice is the flapping notification and its targets.
+type flapNotice struct {
+	n       *notification
+	targets []target_t
+}
+
+// flapDue returns the digests and the summaries of the stabilized alerts to send. Drops the expired states.
+func (s *alertStore_t) flapDue(now time.Time) []flapNotice {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []flapNotice
+	for fp, f := range s.flaps {
+		last := time.Time{}
+		if len(f.transitions) > 0 {
+			last = f.transitions[len(f.transitions)-1]
+		}
+		if !f.flapping {
+			if now.Sub(f.lastSeen) > alertStateExpire {
+				delete(s.flaps, fp)
+			}
+			continue
+		}
+		if now.Sub(last) >= time.Duration(f.opts.Stable) {
+			due = append(due, f.notice(fmt.Sprintf("%s stopped flapping: %d transitions in %s, now %s",
+				f.name, f.count, roundDuration(last.Sub(f.since)), strings.ToUpper(f.status))))
+			delete(s.flaps, fp)
+			// The alert is re-notified and escalated by the status it has settled at
+			if st, ok := s.states[fp]; ok && st.status != f.status {
+				if f.status == "resolved" {
+					delete(s.states, fp)
+				} else {
+					st.status = f.status
+					st.since = last
+				}
+			}
+			continue
+		}
+		if now.Sub(f.lastDigest) >= time.Duration(f.opts.Digest) && f.count > f.digested {
+			due = append(due, f.notice(fmt.Sprintf("%s flapped %d times in %s, now %s",
+				f.name, f.count, roundDuration(now.Sub(f.since)), strings.ToUpper(f.status))))
+			f.digested = f.count
+			f.lastDigest = now
+		}
+	}
+	return due
+}
+
+// notice builds the flapping notification to the targets of the last notification of the alert.
+func (f *flapState_t) notice(text string) flapNotice {
+	n := &notification{
+		alert:   f.n.alert,
+		body:    f.n.body,
+		status:  f.status,
+		subject: "[FLAPPING] " + f.name,
+		msg:     "****** Flapping *****\n" + text,
+	}
+	return flapNotice{n: n, targets: f.targets}
+}
+
+func roundDuration(d time.Duration) time.Duration {
+	if d < time.Minute {
+		return d.Round(time.Second)
+	}
+	return d.Round(time.Minute)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dedupOptions_t controls repeated notifications of the alert (by fingerprint, /alert only).
+// Set by the "dedup" section of WEBHOOK_CONFIG file, routes can override the options.
+type dedupOptions_t struct {
+	Window   duration_t `json:"window,omitempty"`   // the same status of the alert is sent once inside the window, 0 - no dedup
+	Renotify duration_t `json:"renotify,omitempty"` // firing alert is sent again every renotify until resolved, Grafana repeats in between are dropped
+	Resolved *bool      `json:"resolved,omitempty"` // send resolved notifications, true by default
+}
+
+// merge returns the options overridden by the set fields of o.
+func (d dedupOptions_t) merge(o *dedupOptions_t) dedupOptions_t {
+	if o == nil {
+		return d
+	}
+	if o.Window > 0 {
+		d.Window = o.Window
+	}
+	if o.Renotify > 0 {
+		d.Renotify = o.Renotify
+	}
+	if o.Resolved != nil {
+		d.Resolved = o.Resolved
+	}
+	return d
+}
+
+func (d dedupOptions_t) sendResolved() bool {
+	return d.Resolved == nil || *d.Resolved
+}
+
+// dedupOptions returns the options of the first matching route having them, over the global ones.
+func (c *config_t) dedupOptions(labels map[string]string) dedupOptions_t {
+
+	opts := dedupOptions_t{}.merge(c.Dedup)
+	for _, r := range c.Routes {
+		if r.Dedup != nil && r.matches(labels) {
+			opts = opts.merge(r.Dedup)
+			break
+		}
+	}
+	return opts
+}
+
+// The state of the alert not updated by Grafana for this time is dropped, and it is not re-notified anymore.
+const alertStateExpire = 24 * time.Hour
+
+// alertState_t is the last sent notification of the alert.
+type alertState_t struct {
+	status   string
+	since    time.Time // first notification of the status
+	lastSent time.Time
+	lastSeen time.Time // last notification from Grafana
+	renotify time.Duration
+	n        *notification // to re-notify, to escalate
+	targets  []target_t
+
+	escalation *escalation_t // nil - no escalation
+	step       int           // next escalation step
+	escalated  []target_t    // targets of the done steps
+	acked      bool
+	ackedBy    string
+	ackedAt    time.Time
+}
+
+// alertStore_t keeps the state of the alerts by fingerprint, the active alerts table.
+type alertStore_t struct {
+	mu     sync.Mutex
+	states map[string]*alertState_t
+	flaps  map[string]*flapState_t
+}
+
+func newAlertStore() *alertStore_t {
+	return &alertStore_t{
+		states: make(map[string]*alertState_t),
+		flaps:  make(map[string]*flapState_t),
+	}
+}
+
+// check tells whether the notification of the alert is to be sent. If not, returns the reason.
+func (s *alertStore_t) check(fingerprint string, status string, opts dedupOptions_t) (bool, string) {
+
+	if status == "resolved" && !opts.sendResolved() {
+		s.mu.Lock()
+		delete(s.states, fingerprint)
+		s.mu.Unlock()
+		return false, "resolved notifications are off"
+	}
+	if len(fingerprint) == 0 {
+		return true, ""
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.states[fingerprint]
+	if !ok || st.status != status {
+		return true, ""
+	}
+	now := time.Now()
+	st.lastSeen = now
+
+	hold := time.Duration(opts.Window)
+	if status == "firing" && time.Duration(opts.Renotify) > hold {
+		hold = time.Duration(opts.Renotify)
+	}
+	if now.Sub(st.lastSent) < hold {
+		return false, "duplicate, sent at " + st.lastSent.Format(time.TimeOnly)
+	}
+	return true, ""
+}
+
+// observe records the status of the alert from Grafana before the notification is muted or collapsed.
+// The state of the resolved alert is dropped, it is not re-notified and escalated anymore.
+func (s *alertStore_t) observe(fingerprint string, status string) {
+
+	if len(fingerprint) == 0 || status != "resolved" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if st, ok := s.states[fingerprint]; ok && st.status != status {
+		delete(s.states, fingerprint)
+	}
+}
+
+// sent records the notification of the alert to the targets, delivered is false if all of them failed.
+// The undelivered notification is not a duplicate for the dedup window. The escalation of the firing alert
+// and its acknowledgement are kept until the status changes.
+func (s *alertStore_t) sent(n *notification, targets []target_t, delivered bool, opts dedupOptions_t, escalation *escalation_t) {
+
+	if len(n.fingerprint) == 0 {
+		return
+	}
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.states[n.fingerprint]
+	if !ok || st.status != n.status {
+		st = &alertState_t{status: n.status, since: now}
+		s.states[n.fingerprint] = st
+	}
+	if delivered {
+		st.lastSent = now
+	}
+	st.lastSeen = now
+	st.n = n
+	st.targets = targets
+	st.renotify = 0
+	if n.status == "firing" {
+		st.renotify = time.Duration(opts.Renotify)
+		if st.escalation == nil {
+			st.escalation = escalation
+		}
+	}
+}
+
+// run re-notifies and escalates the firing alerts, sends flapping digests, and drops the expired states.
+func (s *alertStore_t) run(ctx context.Context, deliver func(n *notification, targets []target_t) error) {
+
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, st := range s.due(time.Now()) {
+			n := *st.n
+			n.pin = false // pinned by the first message already
+			n.dm = false  // the users got the first message directly
+			n.subject = strings.Replace(n.subject, "[FIRING]", "[FIRING, REMINDER]", 1)
+			n.msg = "[REMINDER]\n" + n.msg
+			if err := deliver(&n, st.targets); err != nil {
+				slog.Error("Alert-Webhook, re-notify error", "fingerprint", n.fingerprint, "err", err)
+				continue
+			}
+			slog.Info("Alert-Webhook, re-notified", "fingerprint", n.fingerprint)
+		}
+		for _, f := range s.flapDue(time.Now()) {
+			if err := deliver(f.n, f.targets); err != nil {
+				slog.Error("Alert-Webhook, flapping digest error", "subject", f.n.subject, "err", err)
+			}
+		}
+		for _, e := range s.escalationDue(time.Now()) {
+			if err := deliver(e.n, e.targets); err != nil {
+				slog.Error("Alert-Webhook, escalation error", "fingerprint", e.n.fingerprint, "err", err)
+				continue
+			}
+			slog.Info("Alert-Webhook, escalated", "fingerprint", e.n.fingerprint, "subject", e.n.subject)
+		}
+	}
+}
+
+// due returns the states to re-notify, marking them as sent. Drops the expired states.
+func (s *alertStore_t) due(now time.Time) []alertState_t {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []alertState_t
+	for fp, st := range s.states {
+		if now.Sub(st.lastSeen) > alertStateExpire {
+			delete(s.states, fp)
+			continue
+		}
+		if f, ok := s.flaps[fp]; ok && f.flapping {
+			continue // in the flapping digest
+		}
+		if st.status == "firing" && !st.acked && st.renotify > 0 && now.Sub(st.lastSent) >= st.renotify {
+			st.lastSent = now
+			due = append(due, *st)
+		}
+	}
+	return due
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAlertStoreDedup(t *testing.T) {
+	s := newAlertStore()
+	opts := dedupOptions_t{Window: duration_t(5 * time.Minute)}
+	firing := &notification{fingerprint: "fp1", status: "firing"}
+
+	if ok, _ := s.check("fp1", "firing", opts); !ok {
+		t.Fatal("First firing is dropped")
+	}
+	s.sent(firing, nil, true, opts, nil)
+	if ok, _ := s.check("fp1", "firing", opts); ok {
+		t.Error("Duplicate firing is sent")
+	}
+	if ok, _ := s.check("fp1", "resolved", opts); !ok {
+		t.Error("Resolved is dropped")
+	}
+
+	// After the window
+	s.states["fp1"].lastSent = time.Now().Add(-6 * time.Minute)
+	if ok, _ := s.check("fp1", "firing", opts); !ok {
+		t.Error("Firing after the window is dropped")
+	}
+
+	off := false
+	if ok, _ := s.check("fp1", "resolved", dedupOptions_t{Resolved: &off}); ok {
+		t.Error("Resolved is sent with resolved off")
+	}
+	if _, ok := s.states["fp1"]; ok {
+		t.Error("State is kept after resolved")
+	}
+
+	// All the targets failed: Grafana repeat is not a duplicate
+	s.sent(&notification{fingerprint: "fp2", status: "firing"}, nil, false, opts, nil)
+	if ok, _ := s.check("fp2", "firing", opts); !ok {
+		t.Error("Repeat of undelivered firing is dropped")
+	}
+}
+
+func TestAlertStoreRenotify(t *testing.T) {
+	s := newAlertStore()
+	opts := dedupOptions_t{Renotify: duration_t(time.Hour)}
+	n := &notification{fingerprint: "fp1", status: "firing", subject: "[FIRING] CPU"}
+	s.sent(n, []target_t{{"telegram", "-100"}}, true, opts, nil)
+
+	// Grafana repeat inside renotify
+	if ok, _ := s.check("fp1", "firing", opts); ok {
+		t.Error("Repeat inside renotify is sent")
+	}
+	if due := s.due(time.Now()); len(due) != 0 {
+		t.Errorf("Re-notified too early")
+	}
+	due := s.due(time.Now().Add(time.Hour))
+	if len(due) != 1 || due[0].n != n || len(due[0].targets) != 1 {
+		t.Fatalf("Expected re-notify of fp1, got %+v", due)
+	}
+	if due := s.due(time.Now().Add(90 * time.Minute)); len(due) != 0 {
+		t.Errorf("Re-notified twice")
+	}
+	if s.due(time.Now().Add(alertStateExpire + 2*time.Hour)); len(s.states) != 0 {
+		t.Errorf("Expired state is kept")
+	}
+}
+
+func TestDedupOptions(t *testing.T) {
+	off := false
+	c := &config_t{
+		Dedup: &dedupOptions_t{Window: duration_t(time.Minute)},
+		Routes: []*route_t{
+			{Match: map[string]string{"team": "db"}, Dedup: &dedupOptions_t{Resolved: &off, Renotify: duration_t(time.Hour)}},
+		},
+	}
+	opts := c.dedupOptions(map[string]string{"team": "db"})
+	if opts.Window != duration_t(time.Minute) || opts.Renotify != duration_t(time.Hour) || opts.sendResolved() {
+		t.Errorf("Unexpected options %+v", opts)
+	}
+	if !c.dedupOptions(map[string]string{"team": "web"}).sendResolved() {
+		t.Errorf("Resolved is off for other routes")
+	}
+}
+
+func TestAlertStoreFlapping(t *testing.T) {
+	s := newAlertStore()
+	opts := flapOptions_t{Threshold: 3, Window: duration_t(30 * time.Minute), Digest: duration_t(10 * time.Minute), Stable: duration_t(15 * time.Minute)}
+	targets := []target_t{{"telegram", "-100"}}
+	s.sent(&notification{fingerprint: "fp1", status: "firing"}, targets, true, dedupOptions_t{}, nil)
+
+	var notices int
+	for i, status := range []string{"firing", "resolved", "firing", "resolved", "firing", "resolved"} {
+		flapping, notice := s.transition(&notification{fingerprint: "fp1", status: status}, "CPU", targets, opts)
+		if notice != nil {
+			notices++
+			if !strings.Contains(notice.n.msg, "CPU is flapping: 3 transitions") || len(notice.targets) != 1 {
+				t.Errorf("Unexpected notice %q", notice.n.msg)
+			}
+		}
+		if expected := i >= 3; flapping != expected {
+			t.Errorf("%d %s: flapping %v", i, status, flapping)
+		}
+	}
+	if notices != 1 {
+		t.Errorf("Expected one flapping notice, got %d", notices)
+	}
+
+	due := s.flapDue(time.Now().Add(11 * time.Minute))
+	if len(due) != 1 || !strings.Contains(due[0].n.msg, "CPU flapped 5 times") {
+		t.Fatalf("Expected digest, got %+v", due)
+	}
+	if due := s.flapDue(time.Now().Add(12 * time.Minute)); len(due) != 0 {
+		t.Errorf("Digest without new transitions")
+	}
+	due = s.flapDue(time.Now().Add(16 * time.Minute))
+	if len(due) != 1 || !strings.Contains(due[0].n.msg, "CPU stopped flapping: 5 transitions") || !strings.Contains(due[0].n.msg, "now RESOLVED") {
+		t.Fatalf("Expected summary, got %+v", due)
+	}
+	if _, ok := s.states["fp1"]; ok {
+		t.Errorf("Alert settled as resolved is kept firing")
+	}
+	if flapping, _ := s.transition(&notification{fingerprint: "fp1", status: "firing"}, "CPU", targets, opts); flapping {
+		t.Errorf("Flapping after the summary")
+	}
+}
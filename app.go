@@ -5,7 +5,6 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
-	"net/url"
 	"time"
 
 	//"bytes"
@@ -19,25 +18,41 @@ import (
 	"context"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
-	//"errors"
 	"bytes"
+	"crypto/subtle"
+	"errors"
+	"sync"
 
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
-
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
 )
 
 type App struct {
 	//router 	*mux.Router
-	srv      *http.Server
-	ctx      context.Context
-	bot      *bot.Bot
-	chatID   int64
-	myMinio  *myMinio_t
-	atClient *atClient_t
+	srv       *http.Server
+	ctx       context.Context
+	bot       *bot.Bot
+	limit     *telegramLimiter_t // Telegram rate limits
+	parseMode models.ParseMode   // Telegram markup, TELEGRAM_PARSE_MODE env: "" - plain text, "HTML"
+	chatID    int64
+	myMinio   *myMinio_t
+	execs     execNotifiers_t // exec notifiers by name, "atclient" for TELEGRAM_BOT_TOKEN=ATCLIENT
+
+	config    *config_t
+	notifiers map[string]notifier // by target kind: "telegram", "email", ...
+	images    *imageCache_t
+	states    *alertStore_t // alerts by fingerprint
+	batches   *batcher_t
+	pins      *pinStore_t // pinned Telegram messages of the alerts
+
+	maintenance *maintenanceStore_t
+	onCall      *onCallStore_t
+	apiToken    string        // bearer token of the API changing the state, WEBHOOK_API_TOKEN env
+	reqTimeout  time.Duration // deadline of the webhook request processing, under the server WriteTimeout. 0 - none
 }
 
 type myMinio_t struct {
@@ -45,6 +60,24 @@ type myMinio_t struct {
 	port   string
 	key    string
 	secret string
+
+	secure       string // "true", "false", "" - by the scheme of imageURL
+	caFile       string // PEM bundle added to the system CAs
+	skipVerify   bool
+	region       string
+	lookup       string // "auto", "path", "dns" (virtual-host style)
+	credentials  string // "static", "iam", "sts", "web-identity"
+	sessionToken string
+	stsEndpoint  string
+	roleARN      string
+	tokenFile    string // web identity token
+	iamEndpoint  string
+
+	mu           sync.Mutex
+	clients      map[string]*minio.Client // by endpoint
+	creds        *credentials.Credentials
+	transport    http.RoundTripper
+	bucketLookup minio.BucketLookupType
 }
 
 type Body struct {
@@ -75,6 +108,7 @@ type AlertBody struct {
 	Fingerprint  string                 `json:"fingerprint,omitempty"`  // The labels fingerprint, alarms with the same labels will have the same fingerprint.
 	SilenceURL   string                 `json:"silenceURL,omitempty"`   // URL to silence the alert rule in the Grafana UI.
 	DashboardURL string                 `json:"dashboardURL,omitempty"` // A link to the Grafana Dashboard if the alert has a Dashboard UID annotation.
+	PanelURL     string                 `json:"panelURL,omitempty"`     // A link to the panel if the alert has a Panel ID annotation.
 	ImageURL     string                 `json:"imageURL,omitempty"`     // URL of a screenshot of a panel assigned to the rule that created this notification.
 }
 
@@ -99,6 +133,17 @@ func (a *App) Initialize(ctx context.Context, botToken string, chatID int64, add
 			return err
 		}
 		a.bot = mbot
+		limit, err := newTelegramLimiter()
+		if err != nil {
+			return err
+		}
+		a.limit = limit
+		switch mode := os.Getenv("TELEGRAM_PARSE_MODE"); mode {
+		case "", "HTML":
+			a.parseMode = models.ParseMode(mode)
+		default:
+			return fmt.Errorf("TELEGRAM_PARSE_MODE %q, should be HTML or empty", mode)
+		}
 	}
 	a.chatID = chatID
 	a.ctx = ctx
@@ -106,18 +151,111 @@ func (a *App) Initialize(ctx context.Context, botToken string, chatID int64, add
 	router := mux.NewRouter()
 	router.HandleFunc("/health", a.HealthCheck).Methods("GET")
 	router.HandleFunc("/alert", a.Alert).Methods("POST")      // Use per-Alert annotation, labels, images
-	router.HandleFunc("/notify", a.Notify).Methods("POST")    // Use Notification Group Message. Only first Immage if there is any.
+	router.HandleFunc("/notify", a.Notify).Methods("POST")    // Use Notification Group Message. Distinct images of the group as an album.
 	router.HandleFunc("/codepage", a.Codepage).Methods("Get") //
-
+	router.HandleFunc("/metrics", a.Metrics).Methods("GET")
+	router.HandleFunc("/maintenance", a.Maintenance).Methods("GET")
+	router.HandleFunc("/maintenance", a.MaintenanceAdd).Methods("POST")
+	router.HandleFunc("/maintenance/{name}", a.MaintenanceDelete).Methods("DELETE")
+	router.HandleFunc("/alerts", a.Alerts).Methods("GET")
+	router.HandleFunc("/alerts/{fingerprint}/ack", a.AlertAck).Methods("POST")
+	router.HandleFunc("/oncall", a.OnCall).Methods("GET")
+	router.HandleFunc("/oncall/{schedule}", a.OnCall).Methods("GET")
+	router.HandleFunc("/oncall/{schedule}/override", a.OnCallOverride).Methods("POST")
+	router.HandleFunc("/oncall/{schedule}/override", a.OnCallCancel).Methods("DELETE")
+	a.apiToken = os.Getenv("WEBHOOK_API_TOKEN")
+	if len(a.apiToken) == 0 {
+		slog.Warn("WEBHOOK_API_TOKEN is not set, maintenance, ack and on-call override API is disabled")
+	}
+
+	// Sending may wait for Telegram retry_after
+	writeTimeout := 8 * time.Second
+	if a.limit != nil {
+		writeTimeout += a.limit.maxRetryWait
+	}
+	a.reqTimeout = writeTimeout - requestMargin
 	a.srv = &http.Server{
 		Handler:      router,
 		Addr:         ":" + addr,
-		WriteTimeout: 8 * time.Second,
+		WriteTimeout: writeTimeout,
 		ReadTimeout:  8 * time.Second,
 	}
 
 	a.myMinio = myMinio
-	a.atClient = atClient
+	if err := myMinio.init(); err != nil {
+		return err
+	}
+
+	config, err := loadConfig(os.Getenv("WEBHOOK_CONFIG"))
+	if err != nil {
+		return err
+	}
+	a.config = config
+	a.images = newImageCache(config.ImageCache)
+	a.states = newAlertStore()
+	go a.states.run(ctx, a.deliverScheduled)
+	a.batches = newBatcher(a.flushBatch)
+	a.pins = newPinStore()
+	a.maintenance = newMaintenanceStore(config.Maintenance)
+	go a.maintenance.run(ctx, a.deliver)
+	a.onCall = newOnCallStore(config.OnCall, config.people)
+	for i, s := range config.Images {
+		if err := s.init(a); err != nil {
+			return fmt.Errorf("image source %d (%s): %w", i+1, s.Name, err)
+		}
+	}
+
+	a.notifiers = map[string]notifier{
+		"telegram": &telegramNotifier_t{a: a},
+		"on-call":  &onCallNotifier_t{a: a},
+	}
+
+	// atclient is the "atclient" exec notifier, unless it is redefined in the config file
+	execConfigs := make(map[string]*execConfig_t)
+	if atClient != nil {
+		execConfigs["atclient"] = atClient.execConfig()
+	}
+	for name, c := range config.Exec {
+		execConfigs[name] = c
+	}
+	a.execs = make(execNotifiers_t)
+	for name, c := range execConfigs {
+		if err := c.init(); err != nil {
+			return fmt.Errorf("exec %s: %w", name, err)
+		}
+		e := &execNotifier_t{name: name, conf: c}
+		if c.Pool != nil && c.Pool.Size > 0 {
+			e.pool = newExecPool(ctx, name, c)
+		}
+		a.execs[name] = e
+	}
+	if len(a.execs) > 0 {
+		a.notifiers["exec"] = a.execs
+	}
+	if botToken == "ATCLIENT" && a.execs["atclient"] == nil {
+		return fmt.Errorf("TELEGRAM_BOT_TOKEN is ATCLIENT, but atclient is not configured")
+	}
+	email, err := newEmailNotifier()
+	if err != nil {
+		return err
+	}
+	if email != nil {
+		slog.Info("SMTP notifier has been setup from environment", "SMTP_HOST", email.host, "SMTP_TLS", email.tlsMode)
+		a.notifiers["email"] = email
+	}
+	a.notifiers["teams"] = newTeamsNotifier(ctx)
+	a.notifiers["discord"] = newDiscordNotifier(ctx)
+	matrix, err := newMatrixNotifier(ctx)
+	if err != nil {
+		return err
+	}
+	if matrix != nil {
+		slog.Info("Matrix notifier has been setup from environment", "MATRIX_HOMESERVER", matrix.homeserver)
+		a.notifiers["matrix"] = matrix
+	}
+	if err := config.checkTargets(a.notifiers); err != nil {
+		return fmt.Errorf("config %s: %w", os.Getenv("WEBHOOK_CONFIG"), err)
+	}
 
 	return nil
 }
@@ -136,12 +274,23 @@ func (a *App) Run(c chan string) {
 func (a *App) Shutdown(ctx context.Context) {
 	slog.Info("Srv shutting down..")
 	a.srv.Shutdown(ctx)
+	if a.batches != nil {
+		a.batches.flushAll() // Grafana has got the response for them already
+	}
 }
 
 func (a *App) HealthCheck(w http.ResponseWriter, r *http.Request) {
 	respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
 }
 
+// Metrics exposes the Telegram limiter state in Prometheus text format.
+func (a *App) Metrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if a.limit != nil {
+		a.limit.writeMetrics(w)
+	}
+}
+
 func (a *App) Codepage(w http.ResponseWriter, r *http.Request) {
 
 	slog.Info("New Codepage request", "from", r.RemoteAddr, "Length", strconv.FormatInt(r.ContentLength, 10))
@@ -160,13 +309,7 @@ func (a *App) Codepage(w http.ResponseWriter, r *http.Request) {
 	msg := fmt.Sprintf("%s.%s", text, "message-сообщение")
 	fmt.Fprintf(w, "Msg: %s\n", msg)
 	fmt.Fprintf(w, "Msg-q: %q\n", msg)
-	var err error
-
-	if a.bot == nil { // ATCLIENT
-		err = a.atClientTelegram(a.chatID, msg, "")
-	} else { // DIRECT
-		err = a.directTelegram(a.chatID, msg, "")
-	}
+	err := a.notifiers["telegram"].send(&notification{msg: msg}, strconv.FormatInt(a.chatID, 10))
 	if err != nil {
 		fmt.Fprintln(w, "Telegram send error")
 		slog.Error("Codepage-Webhook, Telegram send error", "err", err)
@@ -185,6 +328,10 @@ func (a *App) Alert(w http.ResponseWriter, r *http.Request) {
 	//var m Body
 
 	slog.Info("New Alert request", "from", r.RemoteAddr, "Length", strconv.FormatInt(r.ContentLength, 10))
+	ctx, cancel := a.requestContext()
+	defer cancel()
+	renderCtx, cancelRender := context.WithTimeout(ctx, renderMaxTimeout) // all the alerts of the request
+	defer cancelRender()
 
 	m := &Body{} // top-level body of alerts, containing common labels, links, etc
 
@@ -211,6 +358,8 @@ func (a *App) Alert(w http.ResponseWriter, r *http.Request) {
 		respondWithJSON(w, http.StatusBadRequest, map[string]string{"result": "error", "message": "Invalid JSON Format"})
 		return
 	}
+	// Relay to the forwarders after the alerts have been processed
+	defer a.forward("/alert", m, body)
 
 	//fmt.Printf("Decoded body debug: m=%+v\n", *m)
 	//fmt.Println("")
@@ -235,6 +384,7 @@ func (a *App) Alert(w http.ResponseWriter, r *http.Request) {
 		tz, _ = time.LoadLocation("")
 	}
 
+	var sendErrs []string // by alert
 	for i, alert := range m.Alerts {
 		slog.Info("Alert-Webhook", "Alert_Num", i+1, "json", *alert)
 
@@ -291,57 +441,80 @@ func (a *App) Alert(w http.ResponseWriter, r *http.Request) {
 		}
 		slog.Info("   +                      ")
 
-		var chatID int64
-		chatID = -1
-
-		chatID_s, exists := alert.Labels["chatID"]
-		if exists {
-			chatID, err = strconv.ParseInt(chatID_s, 10, 64)
-			if err != nil {
-				slog.Error("Alert-Webhook. Grafana \"chatID\" Label is incorrect.", "err", err)
-				chatID = -1
+		targets := a.targets(alert.Labels, labelChatID(alert.Labels), labelEmails(alert.Labels))
+		if len(targets) == 0 {
+			slog.Warn("Alert-Webhook. Will not send, no targets for the alert (incorrect ChatID ?)")
+			continue
+		}
+		n := &notification{
+			alert:       alert,
+			body:        m,
+			fingerprint: alert.Fingerprint,
+			status:      alert.Status,
+			subject:     fmt.Sprintf("[%s] %s", strings.ToUpper(alert.Status), alertName),
+			msg:         msg,
+		}
+		n.mentions, n.dm = a.config.mentions([]*AlertBody{alert})
+		a.config.applySeverity(n, alert.Labels)
+		if n.status == "resolved" {
+			a.unpinAlert(n.fingerprint)
+		}
+		a.states.observe(n.fingerprint, n.status) // the resolve muted or collapsed below ends the escalation too
+		if a.muted(n, alert.Labels, targets) {
+			continue
+		}
+		flapping, notice := a.states.transition(n, alertName, targets, a.config.flapOptions(alert.Labels))
+		if notice != nil {
+			slog.Warn("Alert-Webhook. Alert is flapping", "fingerprint", alert.Fingerprint, "alertname", alertName)
+			if _, err := a.deliverTargets(ctx, notice.n, notice.targets); err != nil {
+				slog.Error("Alert-Webhook, flapping notice error", "err", err)
 			}
-		} else {
-			chatID = a.chatID
+		}
+		if flapping {
+			slog.Info("Alert-Webhook. Will not send, the alert is flapping", "fingerprint", alert.Fingerprint, "status", alert.Status)
+			continue
+		}
+		dedup := a.config.dedupOptions(alert.Labels)
+		if ok, reason := a.states.check(alert.Fingerprint, alert.Status, dedup); !ok {
+			slog.Info("Alert-Webhook. Will not send", "fingerprint", alert.Fingerprint, "status", alert.Status, "reason", reason)
+			continue
+		}
+		if batch := a.config.batchOptions(alert.Labels); batch != nil {
+			slog.Info("Alert-Webhook, batched", "fingerprint", alert.Fingerprint, "window", time.Duration(batch.Window))
+			a.batches.add(n, targets, batch, dedup)
+			continue
 		}
 
-		if chatID == -1 {
-			slog.Warn("Alert-Webhook. Will not send to Telegram due to incorrect ChatID", "ChatID", strconv.FormatInt(chatID, 10))
-			//respondWithJSON(w, http.StatusBadRequest, map[string]string{"result": "error", "message": "Incorrect ChatID"+strconv.FormatInt(chatID, 10)})
-			//return
-		} else {
-			slog.Info("Alert-Webhook. Sending to Telegram", "ChatID", strconv.FormatInt(chatID, 10))
-
-			fileName, err := a.getImageFileMinio(alert)
-			if err != nil {
-				slog.Error("Alert-Webhook", "err", err)
-			} else if len(fileName) == 0 {
-				slog.Info("Alert-Webhook, getImage: no Image")
-			} else {
-				defer os.Remove(fileName)
-			}
+		n.image, err = a.getImage(renderCtx, alert)
+		if err != nil {
+			slog.Error("Alert-Webhook", "err", err)
+		} else if n.image == nil {
+			slog.Info("Alert-Webhook, getImage: no Image")
+		}
 
-			if a.bot == nil { // ATCLIENT
-				err = a.atClientTelegram(chatID, msg, fileName)
-			} else { // DIRECT
-				err = a.directTelegram(chatID, msg, fileName)
-			}
-			if err != nil {
-				slog.Error("Alert-Webhook, Telegram send error", "err", err)
-				ee := fmt.Sprintf("Telegram send error: %s\n", err)
-				respondWithJSON(w, http.StatusBadRequest, map[string]string{"result": "error", "message": ee})
-				return
-			} else {
-				slog.Info("Alert-Webhook, Telegram sent success")
-			}
+		// Registered even if some targets failed: the alert is escalated and re-notified anyway
+		sent, err := a.deliverTargets(ctx, n, targets)
+		a.register(n, targets, len(sent) > 0, dedup)
+		if err != nil {
+			slog.Error("Alert-Webhook, send error", "fingerprint", alert.Fingerprint, "err", err)
+			sendErrs = append(sendErrs, fmt.Sprintf("%s (%s): %s", alertName, alert.Fingerprint, err))
+			continue
 		}
+		slog.Info("Alert-Webhook, sent success")
 	} // for i, alert := range m.Alerts
+	if len(sendErrs) > 0 {
+		ee := fmt.Sprintf("Send error: %s\n", strings.Join(sendErrs, "; "))
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"result": "error", "message": ee})
+		return
+	}
 	respondWithJSON(w, http.StatusCreated, map[string]string{"result": "success"})
 }
 
 func (a *App) Notify(w http.ResponseWriter, r *http.Request) {
 
 	slog.Info("New Alert-Notify request", "from", r.RemoteAddr, "Length", strconv.FormatInt(r.ContentLength, 10))
+	ctx, cancel := a.requestContext()
+	defer cancel()
 
 	m := &Body{}
 
@@ -360,6 +533,8 @@ func (a *App) Notify(w http.ResponseWriter, r *http.Request) {
 		respondWithJSON(w, http.StatusBadRequest, map[string]string{"result": "error", "message": "Invalid JSON Format"})
 		return
 	}
+	// Relay to the forwarders after the notification has been processed
+	defer a.forward("/notify", m, body)
 
 	slog.Debug("Notify-Webhook", "Common_Labels", *m)
 	slog.Debug("Notify-Webhook", "Alerts_Count", len(m.Alerts))
@@ -367,9 +542,7 @@ func (a *App) Notify(w http.ResponseWriter, r *http.Request) {
 	var msg string
 	var alertWithImage *AlertBody
 	var chatID int64
-
-	//var chatID_s string
-	//var exists   bool
+	var emails []string
 
 	chatID = -1
 	alertWithImage = nil
@@ -379,35 +552,20 @@ func (a *App) Notify(w http.ResponseWriter, r *http.Request) {
 	for i, alert := range m.Alerts {
 		slog.Info("Notify-Webhook", "Alert_Num", i+1, "json", *alert)
 
-		if len(alert.ImageURL) > 0 { // Image URL exists !
-			alertWithImage = alert
+		emails = append(emails, labelEmails(alert.Labels)...)
 
-			chatID_s, exists := alert.Labels["chatID"]
-			if exists {
-				chatID, err = strconv.ParseInt(chatID_s, 10, 64)
-				if err != nil {
-					slog.Error("Notify-Webhook. Grafana ChatID Label is incorrect.", "err", err)
-					chatID = -1
-				}
-				//} else {
-				//	chatID = -1
+		if alertWithImage == nil && len(alert.ImageURL) > 0 { // Image URL exists !
+			alertWithImage = alert
+			// chatID of the alert with image has priority
+			if id := labelChatID(alert.Labels); id != -1 {
+				chatID = id
 			}
-			break
+			continue
 		}
 		if chatID == -1 {
-			chatID_s, exists := alert.Labels["chatID"]
-			if exists {
-				chatID, err = strconv.ParseInt(chatID_s, 10, 64)
-				if err != nil {
-					slog.Warn("Notify-Webhook. Grafana ChatID Label is incorrect.", "err", err)
-					chatID = -1
-				}
-			}
+			chatID = labelChatID(alert.Labels)
 		}
 	}
-	if chatID == -1 {
-		chatID = a.chatID
-	}
 
 	//fmt.Println(msg)
 
@@ -417,37 +575,81 @@ func (a *App) Notify(w http.ResponseWriter, r *http.Request) {
 	}
 	slog.Info("   +                      ")
 
-	if chatID == -1 {
-		slog.Warn("Notify-Webhook. Will not send to Telegram die to incorrect ChatID", "ChatID", strconv.FormatInt(chatID, 10))
+	targets := a.targets(m.CommonLabels, chatID, emails)
+	if len(targets) == 0 {
+		slog.Warn("Notify-Webhook. Will not send, no targets for the notification (incorrect ChatID ?)")
 		respondWithJSON(w, http.StatusBadRequest, map[string]string{"result": "error", "message": "Incorrect Telegram chatID"})
 		return
 	}
-	slog.Info("Notify-Webhook. Sending to Telegram", "ChatID", strconv.FormatInt(chatID, 10))
 
-	fileName, err := a.getImageFileMinio(alertWithImage)
-	if err != nil {
-		slog.Error("Notify-Webhook", "err", err)
-	} else if len(fileName) == 0 {
+	if alertWithImage == nil && len(m.Alerts) > 0 {
+		alertWithImage = m.Alerts[0] // panel may be rendered
+	}
+	renderCtx, cancelRender := context.WithTimeout(ctx, renderMaxTimeout)
+	defer cancelRender()
+	images := a.getImages(renderCtx, m.Alerts)
+	if len(images) == 0 {
 		slog.Info("Notify-Webhook, getImage: no Image")
-	} else {
-		defer os.Remove(fileName)
 	}
-
-	if a.bot == nil { // ATCLIENT
-		err = a.atClientTelegram(chatID, msg, fileName)
-	} else { // DIRECT
-		err = a.directTelegram(chatID, msg, fileName)
+	var image *image_t
+	if len(images) > 0 {
+		image = images[0]
+	}
+
+	n := &notification{
+		alert:   alertWithImage,
+		body:    m,
+		status:  m.Status,
+		subject: m.Title,
+		msg:     msg,
+		image:   image,
+		images:  images,
+		markup:  a.parseMode != "", // Grafana message template
+	}
+	n.mentions, n.dm = a.config.mentions(m.Alerts)
+	a.config.applySeverity(n, m.CommonLabels)
+	if a.muted(n, m.CommonLabels, targets) {
+		respondWithJSON(w, http.StatusCreated, map[string]string{"result": "success", "message": "Muted by maintenance"})
+		return
 	}
-	if err != nil {
-		slog.Error("Notify-Webhook, Telegram send error", "err", err)
-		respondWithJSON(w, http.StatusBadRequest, map[string]string{"result": "error", "message": "Telegram send error"})
+	if _, err = a.deliverTargets(ctx, n, targets); err != nil {
+		slog.Error("Notify-Webhook, send error", "err", err)
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"result": "error", "message": "Send error"})
 	} else {
-		slog.Info("Notify-Webhook, Telegram sent success")
+		slog.Info("Notify-Webhook, sent success")
 		respondWithJSON(w, http.StatusCreated, map[string]string{"result": "success"})
 	}
 
 }
 
+var errNotFound = errors.New("not found")
+
+// Room for the response after the request deadline, before the server WriteTimeout.
+const requestMargin = 2 * time.Second
+
+// requestContext returns the context of the webhook request processing: Telegram rate limit waits and
+// panel renders past the deadline fail, Grafana would time out and repeat the request.
+func (a *App) requestContext() (context.Context, context.CancelFunc) {
+	if a.reqTimeout <= 0 {
+		return context.WithCancel(a.ctx)
+	}
+	return context.WithTimeout(a.ctx, a.reqTimeout)
+}
+
+// authorized checks the bearer token of the API request changing the state.
+// The API is disabled if WEBHOOK_API_TOKEN is not set: anyone reaching the port could mute all the alerts.
+func (a *App) authorized(w http.ResponseWriter, r *http.Request) bool {
+	if len(a.apiToken) == 0 {
+		respondWithJSON(w, http.StatusForbidden, map[string]string{"result": "error", "message": "API is disabled, WEBHOOK_API_TOKEN is not set"})
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+a.apiToken)) == 1 {
+		return true
+	}
+	respondWithJSON(w, http.StatusUnauthorized, map[string]string{"result": "error", "message": "Unauthorized"})
+	return false
+}
+
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	response, _ := json.Marshal(payload)
 
@@ -456,159 +658,245 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.Write(response)
 }
 
-func (a *App) getImageFileMinio(alert *AlertBody) (string, error) {
-	// return string - 	fileName, do not forget to remove it after being used,
-	// 					or "", if there is no image in the alert body.
-	// error - in case of error downloading image (except no-Image case, when err = nil)
-
-	if alert == nil {
-		//slog.Info("getImage: no Image")
-		//return nil, fmt.Errorf("getImage, warning: no Image")
-		return "", nil
-	}
-	imageURL := alert.ImageURL
-	if len(imageURL) == 0 {
-		//slog.Info("getImage: no Image")
-		//return nil, fmt.Errorf("getImage, warning: no Image")
-		return "", nil
-	}
-	u, err := url.Parse(imageURL)
-	if err != nil {
-		return "", fmt.Errorf("getImage: %w", err)
-	}
-
-	host := u.Hostname()
-	if len(a.myMinio.host) > 0 {
-		host = a.myMinio.host
-	}
-	port := u.Port()
-	if len(a.myMinio.port) > 0 {
-		port = a.myMinio.port
+// directTelegram sends the message, with the photo if there is the image.
+// The message not fitting the caption follows the photo with a short caption as a reply.
+// The mentions end the caption or the last message. Returns the first message sent.
+func (a *App) directTelegram(ctx context.Context, chatID int64, msg string, image *image_t, silent bool, mentions []mention_t) (*models.Message, error) {
+
+	if image == nil {
+		return a.sendText(ctx, chatID, msg, 0, silent, mentions)
+	}
+	caption, entities, long := a.mentionCaption(msg, mentions)
+	m, err := a.sendPhoto(ctx, chatID, caption, entities, image, silent)
+	if errors.Is(err, bot.ErrorBadRequest) {
+		// The photo is rejected (dimensions, size), the file is accepted as it is
+		slog.Warn("directTelegram. Photo is rejected, sending as document", "image", image.name, "err", err)
+		err = a.limit.do(ctx, chatID, 1, func() error {
+			var err error
+			m, err = a.bot.SendDocument(ctx, &bot.SendDocumentParams{
+				ChatID:              chatID,
+				Document:            &models.InputFileUpload{Filename: image.name, Data: bytes.NewReader(image.data)},
+				Caption:             caption,
+				ParseMode:           a.parseMode,
+				CaptionEntities:     entities,
+				DisableNotification: silent,
+			})
+			return err
+		})
 	}
-	if len(port) > 0 {
-		host = host + ":" + port
+	if err != nil || !long {
+		return m, err
 	}
+	_, err = a.sendText(ctx, chatID, msg, m.ID, silent, mentions)
+	return m, err
+}
 
-	// Minio client
-	mClient, err := minio.New(host, &minio.Options{
-		Creds:  credentials.NewStaticV4(a.myMinio.key, a.myMinio.secret, ""),
-		Secure: false,
-	})
-	if err != nil {
-		return "", fmt.Errorf("getImage. Minio client create error. Will not send images: %w", err)
+// caption returns the caption for msg, and true if it is short one and msg is to be sent as a reply.
+func (a *App) caption(msg string) (string, bool) {
+	if utf8.RuneCountInString(msg) <= telegramMaxCaption {
+		return msg, false
 	}
+	return shortCaption(msg, a.parseMode == models.ParseModeHTML), true
+}
 
-	path := strings.TrimPrefix(u.Path, "/")
-	bucket, object, found := strings.Cut(path, "/")
-	if found == false {
-		return "", fmt.Errorf("getImage, no filename %s", path)
+// mentionCaption returns the caption for msg with the mentions, and true if msg is to be sent as a reply.
+// The mentions go to the reply then, if they do not fit the caption.
+func (a *App) mentionCaption(msg string, mentions []mention_t) (string, []models.MessageEntity, bool) {
+	caption, long := a.caption(msg)
+	if long || len(mentions) == 0 {
+		return caption, nil, long
 	}
-	ss := strings.Split(object, "/")
-	filePath := "/tmp/" + ss[len(ss)-1]
-
-	// Picture download from Minio
-	err = mClient.FGetObject(a.ctx, bucket, object, filePath, minio.GetObjectOptions{})
-	if err != nil {
-		return "", fmt.Errorf("getImage, image download: %w", err)
+	text, entities := a.withMentions(caption, mentions)
+	if utf8.RuneCountInString(text) <= telegramMaxCaption {
+		return text, entities, false
 	}
-	return filePath, nil
+	return shortCaption(msg, a.parseMode == models.ParseModeHTML), nil, true
 }
 
-func (a *App) directTelegram(chatID int64, msg string, fileName string) error {
-
-	var err error
-	var fileData []byte
-
-	if len(fileName) == 0 {
-		_, err = a.bot.SendMessage(a.ctx, &bot.SendMessageParams{
-			ChatID: chatID,
-			Text:   msg,
+// sendText sends the text split into numbered messages if it is too long, the first one replies to replyTo if it is set.
+// The mentions end the last message, or follow it if they do not fit. Returns the first message.
+func (a *App) sendText(ctx context.Context, chatID int64, text string, replyTo int, silent bool, mentions []mention_t) (*models.Message, error) {
+
+	var first *models.Message
+	parts := splitMessage(text, a.parseMode == models.ParseModeHTML)
+	var entities []models.MessageEntity
+	if len(mentions) > 0 {
+		last, e := a.withMentions(parts[len(parts)-1], mentions)
+		if utf8.RuneCountInString(last) > telegramMaxMessage {
+			last, e = a.withMentions("", mentions)
+			parts = append(parts, last)
+		}
+		parts[len(parts)-1], entities = last, e
+	}
+	for i, part := range parts {
+		params := &bot.SendMessageParams{
+			ChatID:              chatID,
+			Text:                part,
+			ParseMode:           a.parseMode,
+			DisableNotification: silent,
+		}
+		if i == len(parts)-1 {
+			params.Entities = entities
+		}
+		if first == nil && replyTo > 0 {
+			params.ReplyParameters = &models.ReplyParameters{MessageID: replyTo, AllowSendingWithoutReply: true}
+		}
+		var m *models.Message
+		err := a.limit.do(ctx, chatID, 1, func() error {
+			var err error
+			m, err = a.bot.SendMessage(ctx, params)
+			return err
 		})
-	} else {
-		fileData, err = os.ReadFile(fileName)
 		if err != nil {
-			slog.Error("directTelegram. file read error", "fileName", fileName, "err", err)
-			_, err := a.bot.SendMessage(a.ctx, &bot.SendMessageParams{
-				ChatID: chatID,
-				Text:   msg,
+			return first, err
+		}
+		if first == nil {
+			first = m
+		}
+	}
+	return first, nil
+}
+
+// sendPhoto refers to the same image uploaded before by its file_id, or uploads it.
+func (a *App) sendPhoto(ctx context.Context, chatID int64, caption string, entities []models.MessageEntity, image *image_t, silent bool) (*models.Message, error) {
+
+	var m *models.Message
+	if fileID := a.images.fileID(image); len(fileID) > 0 {
+		err := a.limit.do(ctx, chatID, 1, func() error {
+			var err error
+			m, err = a.bot.SendPhoto(ctx, &bot.SendPhotoParams{
+				ChatID:              chatID,
+				Photo:               &models.InputFileString{Data: fileID},
+				Caption:             caption,
+				ParseMode:           a.parseMode,
+				CaptionEntities:     entities,
+				DisableNotification: silent,
 			})
 			return err
-		}
-		_, err = a.bot.SendPhoto(a.ctx, &bot.SendPhotoParams{
-			ChatID:  chatID,
-			Photo:   &models.InputFileUpload{Filename: fileName, Data: bytes.NewReader(fileData)},
-			Caption: msg,
 		})
+		if !errors.Is(err, bot.ErrorBadRequest) {
+			return m, err
+		}
+		slog.Warn("directTelegram. file_id is rejected, uploading the image", "image", image.name, "err", err)
+		a.images.setFileID(image, "")
 	}
-	return err
-}
-func (a *App) sendImage(alert *AlertBody, msg string) error {
-
-	imageURL := alert.ImageURL
-	if len(imageURL) == 0 {
-		slog.Info("no Image")
-		_, err := a.bot.SendMessage(a.ctx, &bot.SendMessageParams{
-			ChatID: a.chatID,
-			Text:   msg,
+	err := a.limit.do(ctx, chatID, 1, func() error {
+		var err error
+		m, err = a.bot.SendPhoto(ctx, &bot.SendPhotoParams{
+			ChatID:              chatID,
+			Photo:               &models.InputFileUpload{Filename: image.name, Data: bytes.NewReader(image.data)},
+			Caption:             caption,
+			ParseMode:           a.parseMode,
+			CaptionEntities:     entities,
+			DisableNotification: silent,
 		})
 		return err
+	})
+	if err == nil {
+		a.images.setFileID(image, photoFileID(m))
 	}
-	u, err := url.Parse(imageURL)
-	if err != nil {
-		return err
+	return m, err
+}
+
+// photoFileID returns file_id of the biggest size of the photo message.
+func photoFileID(m *models.Message) string {
+	if m == nil || len(m.Photo) == 0 {
+		return ""
 	}
-	host := u.Hostname()
-	if len(a.myMinio.host) > 0 {
-		host = a.myMinio.host
+	return m.Photo[len(m.Photo)-1].FileID
+}
+
+// directTelegramGroup sends the images as media group albums, up to 10 images each.
+// The message is the caption of the first image, if it does not fit the caption, it follows the album as a reply.
+// The mentions end the caption or the reply. Returns the first message sent.
+func (a *App) directTelegramGroup(ctx context.Context, chatID int64, msg string, images []*image_t, silent bool, mentions []mention_t) (*models.Message, error) {
+
+	caption, entities, long := a.mentionCaption(msg, mentions)
+
+	var first *models.Message
+	for i := 0; i < len(images); i += maxGroupImages {
+		chunk := images[i:min(i+maxGroupImages, len(images))]
+		if len(chunk) == 1 { // album needs 2 items at least
+			m, err := a.directTelegram(ctx, chatID, "", chunk[0], silent, nil)
+			if err != nil {
+				return first, err
+			}
+			if first == nil {
+				first = m
+			}
+			continue
+		}
+		chunkCaption, chunkEntities := "", []models.MessageEntity(nil)
+		if i == 0 {
+			chunkCaption, chunkEntities = caption, entities
+		}
+		msgs, err := a.sendMediaGroup(ctx, chatID, chunkCaption, chunkEntities, chunk, i, true, silent)
+		if err != nil {
+			return first, err
+		}
+		if first == nil && len(msgs) > 0 {
+			first = msgs[0]
+		}
 	}
-	port := u.Port()
-	if len(a.myMinio.port) > 0 {
-		port = a.myMinio.port
+	if !long {
+		return first, nil
 	}
-	if len(port) > 0 {
-		host = host + ":" + port
+	replyTo := 0
+	if first != nil {
+		replyTo = first.ID
 	}
+	_, err := a.sendText(ctx, chatID, msg, replyTo, silent, mentions)
+	return first, err
+}
 
-	// Minio client
-	mClient, err := minio.New(host, &minio.Options{
-		Creds:  credentials.NewStaticV4(a.myMinio.key, a.myMinio.secret, ""),
-		Secure: false,
-	})
-	if err != nil {
-		slog.Error("Minio client create error. Will not send images.", "err", err)
+// sendMediaGroup sends one album. Images uploaded before are referred by their file_id,
+// if Telegram rejects them, the album is sent once more with all the images uploaded.
+func (a *App) sendMediaGroup(ctx context.Context, chatID int64, caption string, entities []models.MessageEntity, images []*image_t, offset int, useFileIDs bool, silent bool) ([]*models.Message, error) {
+
+	referred := false
+	var msgs []*models.Message
+	err := a.limit.do(ctx, chatID, len(images), func() error {
+		var media []models.InputMedia // the readers are built per attempt
+		for j, img := range images {
+			photo := &models.InputMediaPhoto{}
+			if fileID := a.images.fileID(img); useFileIDs && len(fileID) > 0 {
+				photo.Media = fileID
+				referred = true
+			} else {
+				photo.Media = fmt.Sprintf("attach://image%d", offset+j)
+				photo.MediaAttachment = bytes.NewReader(img.data)
+			}
+			if j == 0 {
+				photo.Caption = caption
+				photo.ParseMode = a.parseMode
+				photo.CaptionEntities = entities
+			}
+			media = append(media, photo)
+		}
+		var err error
+		msgs, err = a.bot.SendMediaGroup(ctx, &bot.SendMediaGroupParams{
+			ChatID:              chatID,
+			Media:               media,
+			DisableNotification: silent,
+		})
 		return err
+	})
+	if referred && errors.Is(err, bot.ErrorBadRequest) {
+		slog.Warn("directTelegram. file_id is rejected, uploading the album", "err", err)
+		for _, img := range images {
+			a.images.setFileID(img, "")
+		}
+		return a.sendMediaGroup(ctx, chatID, caption, entities, images, offset, false, silent)
 	}
-
-	path := strings.TrimPrefix(u.Path, "/")
-	bucket, object, found := strings.Cut(path, "/")
-	if found == false {
-		slog.Info("no filename", "Path", path)
-		return nil
-	}
-	ss := strings.Split(object, "/")
-	filePath := "/tmp/" + ss[len(ss)-1]
-
-	// Picture download from Minio
-	err = mClient.FGetObject(a.ctx, bucket, object, filePath, minio.GetObjectOptions{})
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	fileData, errReadFile := os.ReadFile(filePath)
-	if errReadFile != nil {
-		return errReadFile
-	}
-
-	params := &bot.SendPhotoParams{
-		ChatID:  a.chatID,
-		Photo:   &models.InputFileUpload{Filename: filePath, Data: bytes.NewReader(fileData)},
-		Caption: msg,
+	for j, m := range msgs {
+		if j < len(images) {
+			a.images.setFileID(images[j], photoFileID(m))
+		}
 	}
-
-	_, err = a.bot.SendPhoto(a.ctx, params)
-
-	return err
-
+	return msgs, nil
 }
 
 //func (a *App) sendAtclient(alert *AlertBody, msg string) (error) {
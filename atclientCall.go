@@ -1,16 +1,18 @@
 package main
 
 import (
-	"bytes"
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
 
 	//"strings"
 	"log/slog"
-	"strconv"
 	"time"
 )
 
@@ -21,33 +23,73 @@ type atClient_t struct {
 	//botServer string
 	//port string
 	timeout time.Duration
+
+	// Persistent workers, see execPool_t. poolSize = 0 - Java process per message.
+	poolSize   int
+	poolQueue  int           // requests waiting for a worker, over the pool size
+	poolWait   time.Duration // max wait for an idle worker
+	poolHealth time.Duration // health check interval
+	poolArgs   []string      // arguments switching atclient to the worker mode
+}
+
+// execConfig returns the atclient as the "atclient" exec notifier configuration:
+//
+//	<javaParam> <ChatID> <Body> [<File>]
+//
+// Success is judged by the Java process itself, there is no JSON result.
+func (atClient *atClient_t) execConfig() *execConfig_t {
+
+	c := &execConfig_t{
+		Command: atClient.javaPath,
+		Args:    []string{"{{.Chat}}", "{{.Text}}", "{{.File}}"},
+		Input:   "none",
+		Output:  "text",
+		Timeout: duration_t(atClient.timeout),
+		prefix:  append([]string{}, atClient.javaParam...), // not templates, argv as is
+	}
+
+	if atClient.poolSize > 0 {
+		c.Pool = &execPoolConfig_t{
+			Size:   atClient.poolSize,
+			Queue:  atClient.poolQueue,
+			Wait:   duration_t(atClient.poolWait),
+			Health: duration_t(atClient.poolHealth),
+			Args:   append(append([]string{}, atClient.javaParam...), atClient.poolArgs...),
+		}
+	}
+	return c
 }
 
-// JavaProcess represents a running Java process
-type JavaProcess struct {
+// Output captured from the process. Only the tail is kept, the result is the last line.
+const (
+	execMaxStdout    = 64 * 1024
+	execMaxStderrMsg = 1024 // stderr tail in the error message, whole stderr is logged
+
+	execWaitDelay = 2 * time.Second // for the pipes to close after the process is killed
+)
+
+// ExecProcess represents a running notifier process: Java atclient or any other command
+type ExecProcess struct {
 	cmd     *exec.Cmd
 	stdin   io.WriteCloser
 	stdout  io.ReadCloser
 	stderr  io.ReadCloser
 	timeout time.Duration
+	name    string        // for logging
+	exited  chan struct{} // closed by Execute after cmd.Wait
 }
 
-// NewJavaProcess creates and starts a new Java process
-func (a *App) NewJavaProcess(args []string) (*JavaProcess, error) {
-
-	atClient := a.atClient
+// newExecProcess creates and starts a new process of the exec notifier
+func newExecProcess(conf *execConfig_t, args []string) (*ExecProcess, error) {
 
 	// Create the command
 
-	javaArgs := append(atClient.javaParam, args...)
-
-	//fmt.Println("Java argiments:")
+	str := fmt.Sprintf("%s, %v, %s", conf.Command, args, time.Duration(conf.Timeout))
+	slog.Info("newExecProcess.", "arguments:", str)
 
-	str := fmt.Sprintf("%s, %v, %s", atClient.javaPath, javaArgs, atClient.timeout)
-	slog.Info("NewJavaProcess.", "Java arguments:", str)
-	//fmt.Printf("Java arguments: %s, %v, %s\n", atClient.javaPath, javaArgs, atClient.timeout)
-
-	cmd := exec.Command(atClient.javaPath, javaArgs...)
+	cmd := exec.Command(conf.Command, args...)
+	cmd.Env = conf.environ()
+	setProcessGroup(cmd)
 
 	// Set up pipes
 	stdin, err := cmd.StdinPipe()
@@ -82,135 +124,139 @@ func (a *App) NewJavaProcess(args []string) (*JavaProcess, error) {
 		stdin.Close()
 		stdout.Close()
 		stderr.Close()
-		return nil, fmt.Errorf("error starting Java process: %w", err)
+		return nil, fmt.Errorf("error starting process: %w", err)
 	}
 
-	return &JavaProcess{
+	return &ExecProcess{
 		cmd:     cmd,
 		stdin:   stdin,
 		stdout:  stdout,
 		stderr:  stderr,
-		timeout: atClient.timeout,
+		timeout: time.Duration(conf.Timeout),
+		name:    filepath.Base(conf.Command),
+		exited:  make(chan struct{}),
 	}, nil
 }
 
-// Execute sends input to Java and returns the output
-func (jp *JavaProcess) Execute(input string) (string, error) {
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	buf       []byte
+	max       int
+	truncated bool
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > t.max {
+		t.buf = append(t.buf[:0], t.buf[len(t.buf)-t.max:]...)
+		t.truncated = true
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	return string(t.buf)
+}
+
+// Execute sends input to the process and returns its (stdout) output.
+// stdout and stderr are drained concurrently, stderr is logged line by line.
+// Success is judged by the exit code, the caller checks the structured result in the output.
+func (jp *ExecProcess) Execute(input []byte) (string, error) {
 	// Create context with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), jp.timeout)
 	defer cancel()
 
-	// Channel to collect results
-	resultChan := make(chan struct {
-		output string
-		err    error
-	})
-
-	// Goroutine to handle the execution
+	// Send input to the process, and close stdin for it to get EOF.
+	// In goroutine: the process may not read stdin at all.
 	go func() {
-		// Send input to Java process
-		if input != "" {
-			if _, err := io.WriteString(jp.stdin, input+"\n"); err != nil {
-				resultChan <- struct {
-					output string
-					err    error
-				}{"", fmt.Errorf("error writing to stdin: %w", err)}
-				return
+		if len(input) > 0 {
+			if _, err := jp.stdin.Write(append(input, '\n')); err != nil {
+				slog.Warn("ExecProcess. error writing to stdin", "process", jp.name, "err", err)
 			}
 		}
+		jp.stdin.Close()
+	}()
 
-		// Read output
-		var outputBuf bytes.Buffer
-		if _, err := io.Copy(&outputBuf, jp.stdout); err != nil {
-			resultChan <- struct {
-				output string
-				err    error
-			}{"", fmt.Errorf("error reading stdout: %w", err)}
-			return
-		}
-
-		// Read error output
-		var errorBuf bytes.Buffer
-		if _, err := io.Copy(&errorBuf, jp.stderr); err != nil {
-			resultChan <- struct {
-				output string
-				err    error
-			}{"", fmt.Errorf("error reading stderr: %w", err)}
-			return
-		}
+	var readers sync.WaitGroup
+	stdout := &tailBuffer{max: execMaxStdout}
+	stderr := &tailBuffer{max: execMaxStderrMsg}
 
-		if errorBuf.Len() > 0 {
-			resultChan <- struct {
-				output string
-				err    error
-			}{"", fmt.Errorf("Java error: %s", errorBuf.String())}
-			return
+	readers.Add(2)
+	go func() {
+		defer readers.Done()
+		io.Copy(stdout, jp.stdout)
+	}()
+	go func() {
+		defer readers.Done()
+		r := bufio.NewReader(jp.stderr)
+		for {
+			line, err := r.ReadString('\n')
+			if len(line) > 0 {
+				stderr.Write([]byte(line))
+				slog.Warn("ExecProcess", "process", jp.name, "stderr", strings.TrimRight(line, "\r\n"))
+			}
+			if err != nil {
+				return
+			}
 		}
+	}()
 
-		resultChan <- struct {
-			output string
-			err    error
-		}{outputBuf.String(), nil}
+	// Wait closes the pipes, read them till the end first
+	waitChan := make(chan error, 1)
+	go func() {
+		readers.Wait()
+		waitChan <- jp.cmd.Wait()
+		close(jp.exited)
 	}()
 
 	// Wait for results or timeout
+	var err error
 	select {
 	case <-ctx.Done():
 		jp.terminate()
-		return "", errors.New("Java execution timed out")
-	case result := <-resultChan:
-		return result.output, result.err
+		select {
+		case <-waitChan:
+		case <-time.After(execWaitDelay):
+			// A process out of the group still keeps the pipes open, stop reading them
+			slog.Warn("ExecProcess. pipes are still open after kill", "process", jp.name)
+			jp.stdout.Close()
+			jp.stderr.Close()
+			<-waitChan
+		}
+		return stdout.String(), errors.New("process execution timed out")
+	case err = <-waitChan:
 	}
-}
-
-// Close terminates the Java process
-func (jp *JavaProcess) Close() error {
-	jp.stdin.Close()
-	jp.stdout.Close()
-	jp.stderr.Close()
-	return jp.cmd.Wait()
-}
-
-// terminate kills the process if it's still running
-func (jp *JavaProcess) terminate() {
-	jp.stdin.Close()
-	jp.stdout.Close()
-	jp.stderr.Close()
-	jp.cmd.Process.Kill()
-}
-
-func (a *App) atClientTelegram(chatID int64, msg string, fileName string) error {
 
-	var err error
-
-	// func (a *App) NewJavaProcess(args []string) (*JavaProcess, error), where args:
-	//	<ChatID>  [<MessageId: <MID>>] [<ParseMode: <PM>>] <Body> [<FIle>]
-
-	//javaArgs := []string { "\"" + strconv.FormatInt(chatID, 10) + "\"", "\"" + msg + "\"" }
-	javaArgs := []string{strconv.FormatInt(chatID, 10), msg}
-	if len(fileName) > 0 {
-		//javaArgs = append(javaArgs, "\"" + fileName + "\"")
-		javaArgs = append(javaArgs, fileName)
+	for _, line := range strings.Split(strings.TrimSpace(stdout.String()), "\n") {
+		slog.Debug("ExecProcess", "process", jp.name, "stdout", line)
 	}
-	javaProcess, err := a.NewJavaProcess(javaArgs)
-	if err != nil {
-		//fmt.Printf("Failed to start Java process: %v\n", err)
-		return err
+	if stdout.truncated {
+		slog.Warn("ExecProcess. stdout is truncated", "process", jp.name, "kept", execMaxStdout)
 	}
-	defer javaProcess.Close()
 
-	// Execute with input
-	output, err := javaProcess.Execute("")
-
-	//if err != nil {
-	//	fmt.Printf("Error executing Java: %v\n", err)
-	//	return err
-	//}
-
-	//slog.Info("atClientTelegram", "Java output:", output)
-	fmt.Println("atClientTelegram. Java process output:")
-	fmt.Println(output)
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return stdout.String(), fmt.Errorf("process exit code %d: %s", exitErr.ExitCode(), strings.TrimSpace(stderr.String()))
+		}
+		return stdout.String(), fmt.Errorf("process: %w", err)
+	}
+	return stdout.String(), nil
+}
 
-	return err
+// Close terminates the process if it is still running
+func (jp *ExecProcess) Close() error {
+	select {
+	case <-jp.exited:
+		return nil
+	default:
+	}
+	jp.terminate()
+	return nil
+}
 
+// terminate kills the process and its children if they are still running
+func (jp *ExecProcess) terminate() {
+	jp.stdin.Close()
+	killProcessGroup(jp.cmd)
 }
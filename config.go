@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// config_t is the optional JSON configuration file, set by WEBHOOK_CONFIG env.
+// Simple parameters (tokens, hosts, ports) stay in env, the file keeps structured ones.
+type config_t struct {
+	Routes     []*route_t               `json:"routes,omitempty"`
+	Forwarders []*forwarder_t           `json:"forwarders,omitempty"`
+	Exec       map[string]*execConfig_t `json:"exec,omitempty"` // exec notifiers by name
+	Images     []*imageSource_t         `json:"images,omitempty"`
+	Render     *renderConfig_t          `json:"render,omitempty"`
+	Dedup      *dedupOptions_t          `json:"dedup,omitempty"`
+	Flap       *flapOptions_t           `json:"flap,omitempty"`
+	Batch      *batchOptions_t          `json:"batch,omitempty"`
+
+	Maintenance []*maintenance_t         `json:"maintenance,omitempty"`
+	Escalations map[string]*escalation_t `json:"escalations,omitempty"` // policies by name
+	OnCall      map[string]*onCall_t     `json:"oncall,omitempty"`      // rotations by name
+	People      map[string]string        `json:"people,omitempty"`      // person -> target or Telegram chat ID
+	Severity    map[string]*severity_t   `json:"severity,omitempty"`    // by level of the "severity" label
+
+	people map[string]target_t
+
+	ImageProcess *imageProcess_t     `json:"imageProcess,omitempty"`
+	ImageCache   *imageCacheConfig_t `json:"imageCache,omitempty"`
+}
+
+// route_t assigns delivery targets to alerts by their labels.
+// Routes are checked in order, the first matched route wins unless Continue is set.
+type route_t struct {
+	Name     string            `json:"name,omitempty"`
+	Match    map[string]string `json:"match,omitempty"`    // label = value, all of them must match. Empty Match matches any alert.
+	Targets  []string          `json:"targets,omitempty"`  // "<kind>:<destination>", e.g. "telegram:-100123", "email:ops@example.com"
+	Continue bool              `json:"continue,omitempty"` // keep checking next routes after this one matched
+	Render   *renderOptions_t  `json:"render,omitempty"`   // panel rendering options of the alerts without image
+	Dedup    *dedupOptions_t   `json:"dedup,omitempty"`    // repeat suppression, re-notify and resolved options
+	Flap     *flapOptions_t    `json:"flap,omitempty"`     // flapping detection, threshold -1 switches it off
+	Batch    *batchOptions_t   `json:"batch,omitempty"`    // grouping of /alert alerts into one message
+
+	Escalation string `json:"escalation,omitempty"` // name of the escalation policy, "none" - no escalation
+
+	NotifyUsers []string `json:"notifyUsers,omitempty"` // Telegram users mentioned in group chats: "@username", user ID, person
+	NotifyDM    bool     `json:"notifyDM,omitempty"`    // the users get a direct message too
+}
+
+func loadConfig(fileName string) (*config_t, error) {
+
+	c := &config_t{}
+	if len(fileName) == 0 {
+		return c, nil
+	}
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("loadConfig: %w", err)
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return nil, fmt.Errorf("loadConfig %s: %w", fileName, err)
+	}
+	c.people = make(map[string]target_t)
+	for name, p := range c.People {
+		t, err := parsePerson(p)
+		if err != nil {
+			return nil, fmt.Errorf("loadConfig, person %s: %w", name, err)
+		}
+		c.people[name] = t
+	}
+	for name, o := range c.OnCall {
+		if err := o.init(c.people); err != nil {
+			return nil, fmt.Errorf("loadConfig, oncall %s: %w", name, err)
+		}
+	}
+	for level, s := range c.Severity {
+		if err := s.init(); err != nil {
+			return nil, fmt.Errorf("loadConfig, severity %s: %w", level, err)
+		}
+		if level != strings.ToLower(level) {
+			return nil, fmt.Errorf("loadConfig, severity %s: level should be in lower case", level)
+		}
+	}
+	for name, e := range c.Escalations {
+		if err := e.init(name); err != nil {
+			return nil, fmt.Errorf("loadConfig, escalation %s: %w", name, err)
+		}
+	}
+	for i, r := range c.Routes {
+		if _, ok := c.Escalations[r.Escalation]; len(r.Escalation) > 0 && r.Escalation != "none" && !ok {
+			return nil, fmt.Errorf("loadConfig, route %d (%s): no escalation %q", i+1, r.Name, r.Escalation)
+		}
+	}
+	if err := c.checkTargets(nil); err != nil {
+		return nil, fmt.Errorf("loadConfig, %w", err)
+	}
+	if c.ImageProcess != nil {
+		if err := c.ImageProcess.init(); err != nil {
+			return nil, fmt.Errorf("loadConfig, imageProcess: %w", err)
+		}
+	}
+	names := make(map[string]bool)
+	for i, w := range c.Maintenance {
+		if err := w.init(); err != nil {
+			return nil, fmt.Errorf("loadConfig, maintenance %d (%s): %w", i+1, w.Name, err)
+		}
+		if names[w.Name] {
+			return nil, fmt.Errorf("loadConfig, maintenance %d: name %q is not unique", i+1, w.Name)
+		}
+		names[w.Name] = true
+	}
+	for i, f := range c.Forwarders {
+		if err := f.init(); err != nil {
+			return nil, fmt.Errorf("loadConfig, forwarder %d (%s): %w", i+1, f.Name, err)
+		}
+	}
+	return c, nil
+}
+
+// checkTargets checks the targets of the routes, the escalation steps and the severity levels:
+// on-call schedules exist, and the notifiers of the kinds are configured, if notifiers are given.
+func (c *config_t) checkTargets(notifiers map[string]notifier) error {
+
+	check := func(where string, targets []string) error {
+		for _, t := range targets {
+			tt, err := parseTarget(t)
+			if err != nil {
+				return fmt.Errorf("%s: %w", where, err)
+			}
+			if _, ok := c.OnCall[tt.dest]; tt.kind == "on-call" && !ok {
+				return fmt.Errorf("%s: no oncall schedule %q", where, tt.dest)
+			}
+			if _, ok := notifiers[tt.kind]; notifiers != nil && !ok {
+				return fmt.Errorf("%s: %s notifier is not configured", where, t)
+			}
+		}
+		return nil
+	}
+	for i, r := range c.Routes {
+		if err := check(fmt.Sprintf("route %d (%s)", i+1, r.Name), r.Targets); err != nil {
+			return err
+		}
+	}
+	for name, e := range c.Escalations {
+		for i, st := range e.Steps {
+			if err := check(fmt.Sprintf("escalation %s, step %d", name, i+1), st.Targets); err != nil {
+				return err
+			}
+		}
+	}
+	for level, s := range c.Severity {
+		if err := check("severity "+level, s.Targets); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// duration_t is time.Duration in JSON as a string: "5s", "1m30s".
+type duration_t time.Duration
+
+func (d *duration_t) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration should be a string like \"30s\": %w", err)
+	}
+	t, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration_t(t)
+	return nil
+}
+
+func (d duration_t) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (r *route_t) matches(labels map[string]string) bool {
+	return matchLabels(r.Match, labels)
+}
+
+// matchLabels checks that all the match labels are in labels with the same values.
+func matchLabels(match map[string]string, labels map[string]string) bool {
+	for k, v := range match {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// routeTargets returns targets of the routes matching labels,
+// and false if there was no matching route.
+func (c *config_t) routeTargets(labels map[string]string) ([]target_t, bool) {
+
+	var targets []target_t
+	matched := false
+
+	for _, r := range c.Routes {
+		if !r.matches(labels) {
+			continue
+		}
+		matched = true
+		for _, t := range r.Targets {
+			tt, _ := parseTarget(t) // validated by loadConfig
+			targets = append(targets, tt)
+		}
+		if !r.Continue {
+			break
+		}
+	}
+	return targets, matched
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+)
+
+// emailNotifier_t sends alerts as multipart HTML/plain e-mail, the image is embedded inline by CID.
+type emailNotifier_t struct {
+	host     string
+	port     string
+	user     string
+	password string
+	from     string
+	tlsMode  string // "starttls" (default), "tls" - implicit TLS, "none"
+	tlsConf  *tls.Config
+	timeout  time.Duration
+}
+
+// newEmailNotifier returns nil if SMTP_HOST env is not set.
+func newEmailNotifier() (*emailNotifier_t, error) {
+
+	e := &emailNotifier_t{
+		host:     os.Getenv("SMTP_HOST"),
+		port:     os.Getenv("SMTP_PORT"),
+		user:     os.Getenv("SMTP_USER"),
+		password: os.Getenv("SMTP_PASSWORD"),
+		from:     os.Getenv("SMTP_FROM"),
+		tlsMode:  strings.ToLower(os.Getenv("SMTP_TLS")),
+		timeout:  10 * time.Second,
+	}
+	if len(e.host) == 0 {
+		return nil, nil
+	}
+	switch e.tlsMode {
+	case "":
+		e.tlsMode = "starttls"
+	case "starttls", "tls", "none":
+	default:
+		return nil, fmt.Errorf("SMTP_TLS %q, allowed values are starttls, tls, none", e.tlsMode)
+	}
+	if len(e.port) == 0 {
+		e.port = "587"
+		if e.tlsMode == "tls" {
+			e.port = "465"
+		}
+	}
+	if len(e.from) == 0 {
+		if len(e.user) == 0 {
+			return nil, fmt.Errorf("SMTP_FROM env is not set")
+		}
+		e.from = e.user
+	}
+	e.tlsConf = &tls.Config{
+		ServerName:         e.host,
+		InsecureSkipVerify: os.Getenv("SMTP_SKIP_VERIFY") == "true",
+	}
+	if env := os.Getenv("SMTP_TIMEOUT"); len(env) > 0 {
+		t, err := time.ParseDuration(env)
+		if err != nil {
+			return nil, fmt.Errorf("SMTP_TIMEOUT: %w", err)
+		}
+		e.timeout = t
+	}
+	return e, nil
+}
+
+func (e *emailNotifier_t) send(n *notification, dest string) error {
+
+	msg, err := e.compose(n, dest)
+	if err != nil {
+		return fmt.Errorf("email compose: %w", err)
+	}
+	return e.sendMail(dest, msg)
+}
+
+var emailHTML = template.Must(template.New("email").Parse(`<!DOCTYPE html>
+<html><body>
+<div style="font-family:monospace;white-space:pre-wrap">{{.Text}}</div>
+{{- range .Links}}
+<div><a href="{{.URL}}">{{.Name}}</a></div>
+{{- end}}
+{{- if .CID}}
+<p><img src="cid:{{.CID}}" alt="panel"></p>
+{{- end}}
+</body></html>
+`))
+
+type emailLink struct {
+	Name string
+	URL  string
+}
+
+// compose builds the message:
+//
+//	multipart/related
+//	  multipart/alternative
+//	    text/plain
+//	    text/html
+//	  image (inline, Content-ID)
+func (e *emailNotifier_t) compose(n *notification, to string) ([]byte, error) {
+
+	var cid string
+	if n.image != nil {
+		cid = randomID() + "@grafana-webhook"
+	}
+
+	var links []emailLink
+	for _, l := range alertLinks(n.alert) {
+		links = append(links, emailLink{Name: l[0], URL: l[1]})
+	}
+	// Telegram HTML markup of /notify is HTML of the mail as it is
+	text := template.HTML(template.HTMLEscapeString(n.msg))
+	if n.markup {
+		text = template.HTML(n.msg)
+	}
+	var htmlBuf bytes.Buffer
+	err := emailHTML.Execute(&htmlBuf, struct {
+		Text  template.HTML
+		Links []emailLink
+		CID   string
+	}{text, links, cid})
+	if err != nil {
+		return nil, err
+	}
+
+	var altBuf bytes.Buffer
+	alternative := multipart.NewWriter(&altBuf)
+	for _, p := range []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain; charset=utf-8", n.plainMsg()},
+		{"text/html; charset=utf-8", htmlBuf.String()},
+	} {
+		w, err := alternative.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {p.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		qp.Write([]byte(p.body))
+		qp.Close()
+	}
+	alternative.Close()
+
+	var buf bytes.Buffer
+	related := multipart.NewWriter(&buf)
+	subject := n.subject
+	if len(subject) == 0 {
+		subject = "Grafana alert"
+	}
+	hdr := []string{
+		"From: " + e.from,
+		"To: " + to,
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"Message-ID: <" + randomID() + "@" + e.host + ">",
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/related; boundary=\"" + related.Boundary() + "\"",
+	}
+	buf.WriteString(strings.Join(hdr, "\r\n") + "\r\n\r\n")
+
+	altPart, err := related.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"multipart/alternative; boundary=\"" + alternative.Boundary() + "\""},
+	})
+	if err != nil {
+		return nil, err
+	}
+	altPart.Write(altBuf.Bytes())
+
+	if n.image != nil {
+		name := n.image.name
+		contentType := n.image.contentType
+		w, err := related.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {contentType + "; name=\"" + name + "\""},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {"inline; filename=\"" + name + "\""},
+			"Content-ID":                {"<" + cid + ">"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		writeBase64Lines(w, n.image.data)
+	}
+	related.Close()
+
+	return buf.Bytes(), nil
+}
+
+func (e *emailNotifier_t) sendMail(to string, msg []byte) error {
+
+	addr := net.JoinHostPort(e.host, e.port)
+	dialer := &net.Dialer{Timeout: e.timeout}
+
+	var conn net.Conn
+	var err error
+	if e.tlsMode == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, e.tlsConf)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("smtp dial %s: %w", addr, err)
+	}
+	conn.SetDeadline(time.Now().Add(e.timeout))
+
+	c, err := smtp.NewClient(conn, e.host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("smtp: %w", err)
+	}
+	defer c.Close()
+
+	if e.tlsMode == "starttls" {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("smtp %s: server does not support STARTTLS", addr)
+		}
+		if err := c.StartTLS(e.tlsConf); err != nil {
+			return fmt.Errorf("smtp starttls: %w", err)
+		}
+	}
+	if len(e.user) > 0 {
+		if err := c.Auth(smtp.PlainAuth("", e.user, e.password, e.host)); err != nil {
+			return fmt.Errorf("smtp auth: %w", err)
+		}
+	}
+	if err := c.Mail(e.from); err != nil {
+		return fmt.Errorf("smtp MAIL FROM: %w", err)
+	}
+	if err := c.Rcpt(to); err != nil {
+		return fmt.Errorf("smtp RCPT TO %s: %w", to, err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("smtp DATA: %w", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return fmt.Errorf("smtp write: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("smtp DATA: %w", err)
+	}
+	return c.Quit()
+}
+
+func randomID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// writeBase64Lines writes base64 encoded data splitted by 76 chars lines (RFC 2045).
+func writeBase64Lines(w io.Writer, data []byte) {
+	s := base64.StdEncoding.EncodeToString(data)
+	for len(s) > 76 {
+		w.Write([]byte(s[:76] + "\r\n"))
+		s = s[76:]
+	}
+	w.Write([]byte(s + "\r\n"))
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"mime"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpSink accepts one message without TLS and auth, and returns its DATA.
+func smtpSink(t *testing.T) (string, chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := make(chan string, 1)
+	go func() {
+		defer ln.Close()
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 sink")
+		var data strings.Builder
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if inData {
+				if line == ".\r\n" {
+					inData = false
+					c <- data.String()
+					reply("250 OK")
+					continue
+				}
+				data.WriteString(line)
+				continue
+			}
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 sink")
+			case cmd == "DATA":
+				inData = true
+				reply("354 go ahead")
+			case cmd == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+	return ln.Addr().String(), c
+}
+
+func TestEmailNotifier(t *testing.T) {
+	addr, c := smtpSink(t)
+	host, port, _ := net.SplitHostPort(addr)
+
+	e := &emailNotifier_t{host: host, port: port, from: "grafana@example.com", tlsMode: "none", timeout: 5 * time.Second}
+	n := &notification{
+		alert:   &AlertBody{GeneratorURL: "http://grafana/alerting/1"},
+		subject: "[FIRING] Тест",
+		msg:     "****** FIRING ! ******\nT1 <cpu>",
+		image:   &image_t{name: "panel.png", contentType: "image/png", data: []byte("\x89PNG fake image")},
+	}
+	if err := e.send(n, "ops@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	var data string
+	select {
+	case data = <-c:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if subject != "[FIRING] Тест" {
+		t.Errorf("Subject %q", subject)
+	}
+	if !strings.HasPrefix(msg.Header.Get("Content-Type"), "multipart/related") {
+		t.Errorf("Content-Type %q", msg.Header.Get("Content-Type"))
+	}
+	for _, s := range []string{"text/plain", "text/html", "Content-ID: <", "&lt;cpu&gt;", "src=3D\"cid:"} {
+		if !strings.Contains(data, s) {
+			t.Errorf("message does not contain %q", s)
+		}
+	}
+}
+
+func TestEmailMarkup(t *testing.T) {
+	e := &emailNotifier_t{from: "grafana@example.com"}
+	n := &notification{subject: "Disk", msg: "<b>Disk</b> full &amp; more", markup: true}
+	data, err := e.compose(n, "ops@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Markup is the HTML part as it is, and stripped in the text part
+	if !strings.Contains(string(data), "Disk full & more") || strings.Count(string(data), "<b>Disk</b>") != 1 {
+		t.Errorf("Message %s", data)
+	}
+}
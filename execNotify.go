@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// execConfig_t is an external command sending messages: Java atclient, shell or Python scripts, etc.
+// Configured in the "exec" section of WEBHOOK_CONFIG file, targets are "exec:<name>:<chat>".
+//
+// The command gets execRequest as JSON document on stdin and returns execResult as JSON on stdout.
+type execConfig_t struct {
+	Command string            `json:"command"`
+	Args    []string          `json:"args,omitempty"`    // text/template over execRequest, empty results are dropped
+	Input   string            `json:"input,omitempty"`   // "json" (default) - request on stdin, "none" - nothing
+	Output  string            `json:"output,omitempty"`  // "json" (default) - execResult on stdout, "text" - exit code 0 is enough
+	Timeout duration_t        `json:"timeout,omitempty"` // 10s by default
+	Env     map[string]string `json:"env,omitempty"`     // added to the environment of the command, ${ENV} references are expanded
+	Pool    *execPoolConfig_t `json:"pool,omitempty"`    // long-lived workers instead of the process per message, see execPool_t
+
+	args   []*template.Template
+	prefix []string // arguments before Args, passed as they are (atclient javaParam)
+}
+
+type execPoolConfig_t struct {
+	Size   int        `json:"size"`
+	Queue  int        `json:"queue,omitempty"`  // requests waiting for a worker, over the pool size. 4 x size by default
+	Wait   duration_t `json:"wait,omitempty"`   // max wait for an idle worker, 10s by default
+	Health duration_t `json:"health,omitempty"` // health check interval, 30s by default
+	Args   []string   `json:"args,omitempty"`   // full argument list of the worker process
+}
+
+// execRequest is the message to send. In the pool mode it has ID (and Ping for health checks).
+type execRequest struct {
+	ID          int64                  `json:"id,omitempty"`
+	Ping        bool                   `json:"ping,omitempty"`
+	Chat        string                 `json:"chat,omitempty"`
+	ChatID      int64                  `json:"chatId,omitempty"` // Chat, if it is numeric (Telegram)
+	Text        string                 `json:"text,omitempty"`
+	Subject     string                 `json:"subject,omitempty"`
+	Status      string                 `json:"status,omitempty"`
+	Fingerprint string                 `json:"fingerprint,omitempty"`
+	File        string                 `json:"file,omitempty"` // image file name
+	Labels      map[string]string      `json:"labels,omitempty"`
+	Annotations map[string]interface{} `json:"annotations,omitempty"`
+}
+
+type execResult struct {
+	ID        int64  `json:"id,omitempty"`
+	Status    string `json:"status"` // "ok" or "error"
+	MessageID string `json:"messageId,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// execNotifier_t runs the command for every message, or passes it to the pool workers.
+type execNotifier_t struct {
+	name string
+	conf *execConfig_t
+	pool *execPool_t
+}
+
+// execNotifiers_t is the "exec" notifier, destination is "<name>:<chat>".
+type execNotifiers_t map[string]*execNotifier_t
+
+func (c *execConfig_t) init() error {
+
+	if len(c.Command) == 0 {
+		return fmt.Errorf("command is not set")
+	}
+	switch c.Input {
+	case "":
+		c.Input = "json"
+	case "json", "none":
+	default:
+		return fmt.Errorf("input %q, allowed values are json, none", c.Input)
+	}
+	switch c.Output {
+	case "":
+		c.Output = "json"
+	case "json", "text":
+	default:
+		return fmt.Errorf("output %q, allowed values are json, text", c.Output)
+	}
+	if c.Timeout == 0 {
+		c.Timeout = duration_t(10 * time.Second)
+	}
+	c.args = nil
+	for _, a := range c.Args {
+		t, err := template.New("arg").Parse(a)
+		if err != nil {
+			return fmt.Errorf("args: %w", err)
+		}
+		c.args = append(c.args, t)
+	}
+	if p := c.Pool; p != nil && p.Size > 0 {
+		if p.Queue == 0 {
+			p.Queue = 4 * p.Size
+		}
+		if p.Wait == 0 {
+			p.Wait = duration_t(10 * time.Second)
+		}
+		if p.Health == 0 {
+			p.Health = duration_t(30 * time.Second)
+		}
+	}
+	return nil
+}
+
+// environ returns the environment of the command.
+func (c *execConfig_t) environ() []string {
+	if len(c.Env) == 0 {
+		return nil // inherit
+	}
+	env := os.Environ()
+	for k, v := range c.Env {
+		env = append(env, k+"="+os.ExpandEnv(v))
+	}
+	return env
+}
+
+func (c *execConfig_t) buildArgs(req *execRequest) ([]string, error) {
+	args := append([]string{}, c.prefix...)
+	for _, t := range c.args {
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, req); err != nil {
+			return nil, err
+		}
+		if buf.Len() > 0 {
+			args = append(args, buf.String())
+		}
+	}
+	return args, nil
+}
+
+func newExecRequest(n *notification, chat string, fileName string) *execRequest {
+	req := &execRequest{
+		Chat:        chat,
+		Text:        n.msg,
+		Subject:     n.subject,
+		Status:      n.status,
+		Fingerprint: n.fingerprint,
+		File:        fileName,
+	}
+	fmt.Sscan(chat, &req.ChatID)
+	if n.alert != nil {
+		req.Labels = n.alert.Labels
+		req.Annotations = n.alert.Annotations
+	} else if n.body != nil {
+		req.Labels = n.body.CommonLabels
+	}
+	return req
+}
+
+func (e *execNotifier_t) run(n *notification, chat string) (*execResult, error) {
+
+	// The command gets the image as a file, removed after the command is done
+	var fileName string
+	if n.image != nil {
+		f, cleanup, err := n.image.tempFile()
+		if err != nil {
+			return nil, fmt.Errorf("exec %s image: %w", e.name, err)
+		}
+		defer cleanup()
+		fileName = f
+	}
+	req := newExecRequest(n, chat, fileName)
+	if e.pool != nil {
+		return e.pool.send(req)
+	}
+
+	args, err := e.conf.buildArgs(req)
+	if err != nil {
+		return nil, fmt.Errorf("exec %s args: %w", e.name, err)
+	}
+	var input []byte
+	if e.conf.Input == "json" {
+		input, err = json.Marshal(req)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	process, err := newExecProcess(e.conf, args)
+	if err != nil {
+		return nil, err
+	}
+	defer process.Close()
+
+	output, err := process.Execute(input)
+	if err != nil {
+		if res, _ := parseExecResult(output); res != nil && len(res.Error) > 0 {
+			return res, fmt.Errorf("exec %s: %w: %s", e.name, err, res.Error)
+		}
+		return nil, fmt.Errorf("exec %s: %w", e.name, err)
+	}
+	res, err := parseExecResult(output)
+	if errors.Is(err, errNoExecResult) && e.conf.Output == "text" {
+		// Exit code 0 is enough
+		return &execResult{Status: "ok"}, nil
+	}
+	if err != nil {
+		return res, fmt.Errorf("exec %s: %w", e.name, err)
+	}
+	return res, nil
+}
+
+var errNoExecResult = errors.New("no JSON result in the output")
+
+// parseExecResult takes the last JSON line of the output as the result,
+// the command may print something else before it.
+func parseExecResult(output string) (*execResult, error) {
+
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := strings.TrimSpace(lines[i])
+		if !strings.HasPrefix(line, "{") {
+			continue
+		}
+		res := &execResult{}
+		if err := json.Unmarshal([]byte(line), res); err != nil {
+			continue // not a result, just something in braces
+		}
+		if len(res.Status) == 0 {
+			continue
+		}
+		if res.Status != "ok" {
+			return res, fmt.Errorf("result %s: %s", res.Status, res.Error)
+		}
+		return res, nil
+	}
+	return nil, errNoExecResult
+}
+
+func (e execNotifiers_t) send(n *notification, dest string) error {
+
+	name, chat, _ := strings.Cut(dest, ":")
+	en, ok := e[name]
+	if !ok {
+		return fmt.Errorf("exec notifier %q is not configured", name)
+	}
+	_, err := en.run(n, chat)
+	return err
+}
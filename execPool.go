@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// execPool_t keeps pool.size long-lived processes of the exec notifier instead of
+// starting a process (JVM for atclient) for every message.
+//
+// Line-delimited protocol over stdin/stdout of the worker, one JSON document per line,
+// execRequest and execResult with "id":
+//
+//	request:  {"id": 1, "chat": "-100123", "chatId": -100123, "text": "...", "file": "/tmp/x.png", ...}
+//	          {"id": 2, "ping": true}                      - health check
+//	response: {"id": 1, "status": "ok", "messageId": "42"}
+//	          {"id": 1, "status": "error", "error": "..."}
+//
+// Lines of stdout not starting with '{' are logged and skipped. stderr is logged.
+type execPool_t struct {
+	ctx   context.Context
+	name  string
+	conf  *execConfig_t
+	idle  chan *execWorker // idle workers, pool size capacity
+	slots chan struct{}    // running + waiting requests. Full - busy, request is rejected (backpressure)
+	seq   atomic.Int64
+}
+
+type execWorker struct {
+	num    int
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	lines  chan string   // stdout JSON lines
+	exited chan struct{} // closed when the process has exited
+}
+
+var errPoolBusy = errors.New("exec pool is busy")
+
+func newExecPool(ctx context.Context, name string, conf *execConfig_t) *execPool_t {
+
+	pc := conf.Pool
+	p := &execPool_t{
+		ctx:   ctx,
+		name:  name,
+		conf:  conf,
+		idle:  make(chan *execWorker, pc.Size),
+		slots: make(chan struct{}, pc.Size+pc.Queue),
+	}
+	for i := 0; i < pc.Size; i++ {
+		w := &execWorker{num: i + 1}
+		if err := p.start(w); err != nil {
+			// Will be restarted on the first use or health check
+			slog.Error("execPool. Worker start error", "pool", name, "worker", w.num, "err", err)
+		}
+		p.idle <- w
+	}
+	go p.healthCheck()
+
+	slog.Info("execPool started", "pool", name, "size", pc.Size, "queue", pc.Queue)
+	return p
+}
+
+// start (re)starts the worker process.
+func (p *execPool_t) start(w *execWorker) error {
+
+	cmd := exec.Command(p.conf.Command, p.conf.Pool.Args...)
+	cmd.Env = p.conf.environ()
+	setProcessGroup(cmd)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("error creating stdin pipe: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		stdin.Close()
+		return fmt.Errorf("error creating stdout pipe: %w", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		stdin.Close()
+		stdout.Close()
+		return fmt.Errorf("error creating stderr pipe: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		stdin.Close()
+		stdout.Close()
+		stderr.Close()
+		return fmt.Errorf("error starting process: %w", err)
+	}
+
+	w.cmd = cmd
+	w.stdin = stdin
+	w.lines = make(chan string, 16)
+	w.exited = make(chan struct{})
+
+	var readers sync.WaitGroup
+	readers.Add(2)
+	go func(lines chan string) {
+		defer readers.Done()
+		defer close(lines)
+		sc := bufio.NewScanner(stdout)
+		sc.Buffer(make([]byte, 64*1024), 1024*1024)
+		for sc.Scan() {
+			line := sc.Text()
+			if len(line) > 0 && line[0] == '{' {
+				select {
+				case lines <- line:
+				default: // nobody reads the idle worker, the reader is not blocked by it
+					slog.Warn("execPool. Stale line is dropped", "pool", p.name, "worker", w.num, "line", line)
+				}
+			} else if len(line) > 0 {
+				slog.Info("execPool", "pool", p.name, "worker", w.num, "stdout", line)
+			}
+		}
+	}(w.lines)
+	go func() {
+		defer readers.Done()
+		sc := bufio.NewScanner(stderr)
+		for sc.Scan() {
+			slog.Warn("execPool", "pool", p.name, "worker", w.num, "stderr", sc.Text())
+		}
+	}()
+	go func(exited chan struct{}) {
+		readers.Wait() // Wait closes the pipes, read them till the end first
+		err := cmd.Wait()
+		slog.Warn("execPool. Worker exited", "pool", p.name, "worker", w.num, "pid", cmd.Process.Pid, "err", err)
+		close(exited)
+	}(w.exited)
+
+	slog.Info("execPool. Worker started", "pool", p.name, "worker", w.num, "pid", cmd.Process.Pid)
+	return nil
+}
+
+func (w *execWorker) alive() bool {
+	if w.cmd == nil {
+		return false
+	}
+	select {
+	case <-w.exited:
+		return false
+	default:
+		return true
+	}
+}
+
+func (w *execWorker) kill() {
+	if !w.alive() {
+		return
+	}
+	w.stdin.Close()
+	killProcessGroup(w.cmd)
+	select {
+	case <-w.exited:
+	case <-time.After(execWaitDelay):
+		slog.Warn("execPool. Worker is not exited after kill", "pid", w.cmd.Process.Pid)
+	}
+}
+
+// drain drops the lines left by the previous requests of the worker.
+func (w *execWorker) drain() {
+	for {
+		select {
+		case _, ok := <-w.lines:
+			if !ok {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// call sends the request to the worker and waits for its response.
+// The worker is killed if it does not answer in time, it is restarted on the next use.
+func (p *execPool_t) call(w *execWorker, req *execRequest) (*execResult, error) {
+
+	if !w.alive() {
+		if err := p.start(w); err != nil {
+			return nil, err
+		}
+	}
+	w.drain()
+	req.ID = p.seq.Add(1)
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.stdin.Write(append(data, '\n')); err != nil {
+		// Worker has just exited, request was not sent. Restart and try once again.
+		w.kill()
+		if err := p.start(w); err != nil {
+			return nil, err
+		}
+		if _, err := w.stdin.Write(append(data, '\n')); err != nil {
+			w.kill()
+			return nil, fmt.Errorf("exec %s worker %d write: %w", p.name, w.num, err)
+		}
+	}
+
+	timer := time.NewTimer(time.Duration(p.conf.Timeout))
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			w.kill()
+			return nil, fmt.Errorf("exec %s worker %d: timed out", p.name, w.num)
+		case line, ok := <-w.lines:
+			if !ok {
+				return nil, fmt.Errorf("exec %s worker %d: exited", p.name, w.num)
+			}
+			res := &execResult{}
+			if err := json.Unmarshal([]byte(line), res); err != nil {
+				slog.Warn("execPool. Incorrect response", "pool", p.name, "worker", w.num, "line", line, "err", err)
+				continue
+			}
+			if res.ID != req.ID { // late response of a previous request
+				continue
+			}
+			if res.Status != "ok" {
+				return res, fmt.Errorf("exec %s: %s %s", p.name, res.Status, res.Error)
+			}
+			return res, nil
+		}
+	}
+}
+
+// send is executed by one of the idle workers.
+// Requests are waiting for a worker up to pool.wait, and are rejected at once if the queue is full.
+func (p *execPool_t) send(req *execRequest) (*execResult, error) {
+
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		return nil, errPoolBusy
+	}
+	defer func() { <-p.slots }()
+
+	wait := time.Duration(p.conf.Pool.Wait)
+	var w *execWorker
+	select {
+	case w = <-p.idle:
+	case <-time.After(wait):
+		return nil, fmt.Errorf("%w: no idle worker in %s", errPoolBusy, wait)
+	case <-p.ctx.Done():
+		return nil, p.ctx.Err()
+	}
+	defer func() { p.idle <- w }()
+
+	return p.call(w, req)
+}
+
+// healthCheck pings idle workers and restarts the crashed ones.
+// Workers are stopped when the pool context is done.
+func (p *execPool_t) healthCheck() {
+
+	ticker := time.NewTicker(time.Duration(p.conf.Pool.Health))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.ctx.Done():
+			for i := 0; i < cap(p.idle); i++ {
+				select {
+				case w := <-p.idle:
+					w.kill()
+				case <-time.After(time.Duration(p.conf.Timeout)):
+				}
+			}
+			return
+		case <-ticker.C:
+		}
+
+		// Each idle worker is pinged once, busy workers are obviously checked by their requests
+		var idle []*execWorker
+		for i := len(p.idle); i > 0; i-- {
+			select {
+			case w := <-p.idle:
+				idle = append(idle, w)
+			default:
+			}
+		}
+		for _, w := range idle {
+			if _, err := p.call(w, &execRequest{Ping: true}); err != nil {
+				slog.Error("execPool. Health check failed", "pool", p.name, "worker", w.num, "err", err)
+				w.kill()
+				if err := p.start(w); err != nil {
+					slog.Error("execPool. Worker restart error", "pool", p.name, "worker", w.num, "err", err)
+				}
+			}
+			p.idle <- w
+		}
+	}
+}
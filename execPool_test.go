@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Fake pool worker: answers ok to every request, and exits after "crash" text.
+const fakeWorker = `while read -r l; do
+  id=$(echo "$l" | sed 's/.*"id":\([0-9]*\).*/\1/')
+  echo "not a response line"
+  echo "{\"id\":$id,\"status\":\"ok\",\"messageId\":\"m$id\"}"
+  case "$l" in *crash*) exit 1;; esac
+done`
+
+// Fake one-shot sender: echoes chat from the request on stdin as messageId.
+const fakeSender = `read -r l
+chat=$(echo "$l" | sed 's/.*"chat":"\([^"]*\)".*/\1/')
+echo "sending..."
+echo "{\"status\":\"ok\",\"messageId\":\"$chat\"}"`
+
+func testPool(t *testing.T, size int, queue int) *execPool_t {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	conf := &execConfig_t{
+		Command: "sh",
+		Timeout: duration_t(5 * time.Second),
+		Pool: &execPoolConfig_t{
+			Size:   size,
+			Queue:  queue,
+			Wait:   duration_t(5 * time.Second),
+			Health: duration_t(time.Hour),
+			Args:   []string{"-c", fakeWorker},
+		},
+	}
+	return newExecPool(ctx, "test", conf)
+}
+
+func TestExecPoolRestart(t *testing.T) {
+	p := testPool(t, 1, 0)
+
+	for i, text := range []string{"first", "crash", "after crash"} {
+		res, err := p.send(&execRequest{Chat: "-1", Text: text})
+		if err != nil {
+			t.Fatalf("send %d: %v", i, err)
+		}
+		if len(res.MessageID) == 0 {
+			t.Errorf("send %d: no messageId", i)
+		}
+		if text == "crash" {
+			w := <-p.idle
+			<-w.exited
+			p.idle <- w
+		}
+	}
+}
+
+func TestExecPoolBackpressure(t *testing.T) {
+	p := testPool(t, 1, 0)
+
+	w := <-p.idle // the only worker is busy
+	p.slots <- struct{}{}
+	_, err := p.send(&execRequest{Text: "text"})
+	if !errors.Is(err, errPoolBusy) {
+		t.Errorf("Expected busy error, got %v", err)
+	}
+	<-p.slots
+	p.idle <- w
+	if _, err := p.send(&execRequest{Text: "text"}); err != nil {
+		t.Errorf("send: %v", err)
+	}
+}
+
+func TestExecNotifier(t *testing.T) {
+	conf := &execConfig_t{Command: "sh", Args: []string{"-c", fakeSender}}
+	if err := conf.init(); err != nil {
+		t.Fatal(err)
+	}
+	e := &execNotifier_t{name: "test", conf: conf}
+
+	res, err := e.run(&notification{msg: "text", status: "firing"}, "ops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.MessageID != "ops" {
+		t.Errorf("Expected messageId ops, got %q", res.MessageID)
+	}
+}
+
+func TestExecNotifierImage(t *testing.T) {
+	// The file is passed as $0 of the script
+	conf := &execConfig_t{Command: "sh", Args: []string{"-c", `test "$(cat "$0")" = png && echo "{\"status\":\"ok\",\"messageId\":\"$0\"}"`, "{{.File}}"}}
+	if err := conf.init(); err != nil {
+		t.Fatal(err)
+	}
+	e := &execNotifier_t{name: "test", conf: conf}
+
+	img := &image_t{name: "panel.png", contentType: "image/png", data: []byte("png")}
+	res, err := e.run(&notification{msg: "text", image: img}, "ops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(res.MessageID) != "panel.png" {
+		t.Errorf("Unexpected file %q", res.MessageID)
+	}
+	if _, err := os.Stat(res.MessageID); !os.IsNotExist(err) {
+		t.Errorf("File %s is not removed", res.MessageID)
+	}
+}
+
+func TestExecProcessStderr(t *testing.T) {
+	for _, tt := range []struct {
+		script string
+		ok     bool
+	}{
+		// stderr is bigger than the pipe buffer, it must not block the process
+		{`i=0; while [ $i -lt 2000 ]; do echo "JVM warning line $i" >&2; i=$((i+1)); done; echo '{"status":"ok"}'`, true},
+		{`echo '{"status":"ok"}'; echo "boom" >&2; exit 3`, false},
+		{`echo '{"status":"error","error":"chat not found"}'`, false},
+		{`echo "no result"`, false},
+	} {
+		conf := &execConfig_t{Command: "sh", Args: []string{"-c", tt.script}, Input: "none"}
+		if err := conf.init(); err != nil {
+			t.Fatal(err)
+		}
+		e := &execNotifier_t{name: "test", conf: conf}
+		_, err := e.run(&notification{msg: "text"}, "ops")
+		if tt.ok && err != nil {
+			t.Errorf("%s: %v", tt.script, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("%s: expected error", tt.script)
+		}
+	}
+}
+
+func TestAtclientArgs(t *testing.T) {
+	atClient := &atClient_t{javaPath: "java", javaParam: []string{"-Dx=`id`", "-Dy=$(id)", "{{.Chat}}"}, timeout: time.Second}
+	conf := atClient.execConfig()
+	if err := conf.init(); err != nil {
+		t.Fatal(err)
+	}
+	args, err := conf.buildArgs(&execRequest{Chat: "123", Text: "text"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"-Dx=`id`", "-Dy=$(id)", "{{.Chat}}", "123", "text"}
+	if strings.Join(args, "|") != strings.Join(want, "|") {
+		t.Errorf("Args %q", args)
+	}
+}
+
+func TestExecProcessTimeoutChildren(t *testing.T) {
+	// The child of sh keeps stdout open after sh is killed
+	conf := &execConfig_t{Command: "sh", Args: []string{"-c", "sleep 30 & sleep 30"}, Input: "none", Timeout: duration_t(200 * time.Millisecond)}
+	if err := conf.init(); err != nil {
+		t.Fatal(err)
+	}
+	e := &execNotifier_t{name: "test", conf: conf}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := e.run(&notification{msg: "text"}, "ops")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "timed out") {
+			t.Errorf("Expected timeout, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Execute hangs after the timeout")
+	}
+}
+
+func TestExecPoolStaleLines(t *testing.T) {
+	p := testPool(t, 1, 0)
+	p.conf.Pool.Args = []string{"-c", `for i in $(seq 40); do echo '{"id":0,"status":"ok"}'; done
+` + fakeWorker}
+	w := <-p.idle
+	w.kill()
+	if err := p.start(w); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond) // the lines are printed while the worker is idle
+	w.kill()
+	select {
+	case <-w.exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Worker is not exited, stdout reader is blocked")
+	}
+	p.idle <- w
+
+	// Restarted with the lines dropped
+	if _, err := p.send(&execRequest{Chat: "-1", Text: "text"}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var errNoRetry = errors.New("not retryable")
+
+// forwarder_t re-posts the webhook Body received from Grafana to another HTTP service
+// (ticketing system, internal bot, ...), original or transformed by the template.
+type forwarder_t struct {
+	Name       string            `json:"name"`
+	URL        string            `json:"url"`
+	Method     string            `json:"method,omitempty"`     // POST by default
+	Match      map[string]string `json:"match,omitempty"`      // common labels of the Body, empty matches any
+	Template   string            `json:"template,omitempty"`   // text/template over forwardData, original Body if empty
+	Headers    map[string]string `json:"headers,omitempty"`    // ${ENV} references are expanded
+	Auth       *forwardAuth_t    `json:"auth,omitempty"`       //
+	Timeout    duration_t        `json:"timeout,omitempty"`    // per attempt, 10s by default
+	Retries    int               `json:"retries,omitempty"`    // additional attempts after failure
+	RetryDelay duration_t        `json:"retryDelay,omitempty"` // before the first retry, doubled every next one. 2s by default
+
+	tmpl   *template.Template
+	client *http.Client
+}
+
+type forwardAuth_t struct {
+	Type     string `json:"type"` // "basic" or "bearer"
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"` // ${ENV} references are expanded
+	Token    string `json:"token,omitempty"`    // ${ENV} references are expanded
+}
+
+// forwardData is available in the forwarder template.
+type forwardData struct {
+	Body *Body  // decoded Body
+	Raw  string // Body as received
+	Path string // "/alert" or "/notify"
+}
+
+var forwardFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"join":  strings.Join,
+}
+
+func (f *forwarder_t) init() error {
+	if len(f.URL) == 0 {
+		return fmt.Errorf("url is not set")
+	}
+	if len(f.Method) == 0 {
+		f.Method = http.MethodPost
+	}
+	if f.Timeout == 0 {
+		f.Timeout = duration_t(10 * time.Second)
+	}
+	if f.RetryDelay == 0 {
+		f.RetryDelay = duration_t(2 * time.Second)
+	}
+	if f.Auth != nil && f.Auth.Type != "basic" && f.Auth.Type != "bearer" {
+		return fmt.Errorf("auth type %q, allowed values are basic, bearer", f.Auth.Type)
+	}
+	if len(f.Template) > 0 {
+		t, err := template.New(f.Name).Funcs(forwardFuncs).Parse(f.Template)
+		if err != nil {
+			return err
+		}
+		f.tmpl = t
+	}
+	f.client = &http.Client{Timeout: time.Duration(f.Timeout)}
+	return nil
+}
+
+// forward sends the Body to all the matching forwarders. It does not wait for the results.
+func (a *App) forward(path string, m *Body, raw []byte) {
+
+	for _, f := range a.config.Forwarders {
+		if !matchLabels(f.Match, m.CommonLabels) {
+			continue
+		}
+		payload := raw
+		if f.tmpl != nil {
+			var buf bytes.Buffer
+			if err := f.tmpl.Execute(&buf, forwardData{Body: m, Raw: string(raw), Path: path}); err != nil {
+				slog.Error("forward, template error", "forwarder", f.Name, "err", err)
+				continue
+			}
+			payload = buf.Bytes()
+		}
+		go f.post(a.ctx, payload)
+	}
+}
+
+// post sends the payload, retrying with backoff. Returns the last error if it gave up.
+func (f *forwarder_t) post(ctx context.Context, payload []byte) error {
+
+	delay := time.Duration(f.RetryDelay)
+	for attempt := 0; ; attempt++ {
+		err := f.do(ctx, payload)
+		if err == nil {
+			slog.Info("forward, sent success", "forwarder", f.Name)
+			return nil
+		}
+		if attempt >= f.Retries || errors.Is(err, errNoRetry) {
+			slog.Error("forward, send error", "forwarder", f.Name, "attempts", attempt+1, "err", err)
+			return err
+		}
+		slog.Warn("forward, send error, will retry", "forwarder", f.Name, "in", delay, "err", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func (f *forwarder_t) do(ctx context.Context, payload []byte) error {
+
+	req, err := http.NewRequestWithContext(ctx, f.Method, f.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range f.Headers {
+		req.Header.Set(k, os.ExpandEnv(v))
+	}
+	if f.Auth != nil {
+		switch f.Auth.Type {
+		case "basic":
+			req.SetBasicAuth(f.Auth.Username, os.ExpandEnv(f.Auth.Password))
+		case "bearer":
+			req.Header.Set("Authorization", "Bearer "+os.ExpandEnv(f.Auth.Token))
+		}
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		err := fmt.Errorf("%s %s", resp.Status, strings.TrimSpace(string(respBody)))
+		if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			// Request itself is wrong, retry will not help
+			return fmt.Errorf("%w: %w", errNoRetry, err)
+		}
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testForwarder returns the forwarder to the server answering codes in turn, the last one repeated.
+func testForwarder(t *testing.T, retries int, codes ...int) (*forwarder_t, *atomic.Int32) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i := int(calls.Add(1)) - 1
+		w.WriteHeader(codes[min(i, len(codes)-1)])
+	}))
+	t.Cleanup(srv.Close)
+
+	f := &forwarder_t{Name: "test", URL: srv.URL, Retries: retries, RetryDelay: duration_t(time.Millisecond)}
+	if err := f.init(); err != nil {
+		t.Fatal(err)
+	}
+	return f, &calls
+}
+
+func TestForwarderRetry(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		retries int
+		codes   []int
+		calls   int32
+		ok      bool
+	}{
+		{"success", 3, []int{200}, 1, true},
+		{"retried", 3, []int{500, 429, 204}, 3, true},
+		{"gave up", 2, []int{503}, 3, false},
+		{"not retryable", 3, []int{500, 400}, 2, false},
+	} {
+		f, calls := testForwarder(t, tt.retries, tt.codes...)
+		err := f.post(context.Background(), []byte(`{}`))
+		if (err == nil) != tt.ok || calls.Load() != tt.calls {
+			t.Errorf("%s: err %v, calls %d", tt.name, err, calls.Load())
+		}
+		if tt.name == "not retryable" && !errors.Is(err, errNoRetry) {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+
+	// Backoff is canceled with the context
+	f, calls := testForwarder(t, 5, 500)
+	f.RetryDelay = duration_t(time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := f.post(ctx, []byte(`{}`)); !errors.Is(err, context.DeadlineExceeded) || calls.Load() != 1 {
+		t.Errorf("Canceled: err %v, calls %d", err, calls.Load())
+	}
+}
+
+func TestForwarderConfig(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(file, []byte(`{"forwarders": [{"name": "t", "url": "http://t", "retries": 2, "retryDelay": "5s"}]}`), 0o600)
+	c, err := loadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := c.Forwarders[0]; time.Duration(f.RetryDelay) != 5*time.Second || time.Duration(f.Timeout) != 10*time.Second {
+		t.Errorf("Forwarder %+v", f)
+	}
+}
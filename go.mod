@@ -5,6 +5,7 @@ go 1.24.0
 require (
 	github.com/gorilla/mux v1.8.1
 	github.com/minio/minio-go/v7 v7.0.98
+	golang.org/x/image v0.32.0
 )
 
 require (
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// imageCacheConfig_t is the "imageCache" section of WEBHOOK_CONFIG file.
+type imageCacheConfig_t struct {
+	Disabled bool       `json:"disabled,omitempty"`
+	TTL      duration_t `json:"ttl,omitempty"`      // how long the downloaded image is reused, 10m by default
+	MaxBytes int        `json:"maxBytes,omitempty"` // memory for the images, 64 MB by default
+}
+
+// Max number of remembered Telegram file IDs.
+const imageCacheMaxFileIDs = 4096
+
+// imageCache_t keeps downloaded images by their content hash, so one alert sent to several chats,
+// or repeated by Grafana, is downloaded once. It also remembers Telegram file_id of the uploaded images,
+// repeated sends refer to the file instead of uploading it again.
+// nil cache caches nothing.
+type imageCache_t struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	maxBytes int
+	size     int
+
+	urls    map[string]*imageURLEntry // image URL -> content hash
+	blobs   map[string]*list.Element  // content hash -> *image_t, in lru
+	lru     *list.List                // front is recently used
+	fileIDs map[string]string         // content hash -> Telegram file_id
+}
+
+type imageURLEntry struct {
+	hash    string
+	expires time.Time
+}
+
+func newImageCache(c *imageCacheConfig_t) *imageCache_t {
+
+	if c == nil {
+		c = &imageCacheConfig_t{}
+	}
+	if c.Disabled {
+		return nil
+	}
+	ic := &imageCache_t{
+		ttl:      10 * time.Minute,
+		maxBytes: 64 * 1024 * 1024,
+		urls:     make(map[string]*imageURLEntry),
+		blobs:    make(map[string]*list.Element),
+		lru:      list.New(),
+		fileIDs:  make(map[string]string),
+	}
+	if c.TTL > 0 {
+		ic.ttl = time.Duration(c.TTL)
+	}
+	if c.MaxBytes > 0 {
+		ic.maxBytes = c.MaxBytes
+	}
+	return ic
+}
+
+// sum returns the content hash of the image.
+func (img *image_t) sum() string {
+	if len(img.hash) == 0 {
+		h := sha256.Sum256(img.data)
+		img.hash = hex.EncodeToString(h[:])
+	}
+	return img.hash
+}
+
+// get returns the image downloaded from imageURL, nil if it is not cached or expired.
+func (c *imageCache_t) get(imageURL string) *image_t {
+
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.urls[imageURL]
+	if !ok {
+		return nil
+	}
+	el, ok := c.blobs[e.hash]
+	if !ok || time.Now().After(e.expires) {
+		delete(c.urls, imageURL)
+		return nil
+	}
+	c.lru.MoveToFront(el)
+	return el.Value.(*image_t)
+}
+
+// put keeps the image downloaded from imageURL. The same content from different URLs is kept once.
+func (c *imageCache_t) put(imageURL string, img *image_t) {
+
+	if c == nil || len(img.data) > c.maxBytes {
+		return
+	}
+	hash := img.sum()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.urls[imageURL] = &imageURLEntry{hash: hash, expires: time.Now().Add(c.ttl)}
+	if el, ok := c.blobs[hash]; ok {
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.blobs[hash] = c.lru.PushFront(img)
+	c.size += len(img.data)
+
+	for c.size > c.maxBytes {
+		el := c.lru.Back()
+		old := c.lru.Remove(el).(*image_t)
+		delete(c.blobs, old.hash)
+		c.size -= len(old.data)
+	}
+	// Drop expired URLs
+	now := time.Now()
+	for u, e := range c.urls {
+		if now.After(e.expires) {
+			delete(c.urls, u)
+		}
+	}
+}
+
+// fileID returns Telegram file_id of the image uploaded before, "" if there is none.
+func (c *imageCache_t) fileID(img *image_t) string {
+	if c == nil {
+		return ""
+	}
+	hash := img.sum()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.fileIDs[hash]
+}
+
+// setFileID remembers Telegram file_id of the uploaded image, "" forgets it.
+func (c *imageCache_t) setFileID(img *image_t, fileID string) {
+	if c == nil {
+		return
+	}
+	hash := img.sum()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(fileID) == 0 {
+		delete(c.fileIDs, hash)
+		return
+	}
+	if _, ok := c.fileIDs[hash]; !ok && len(c.fileIDs) >= imageCacheMaxFileIDs {
+		for h := range c.fileIDs { // any one
+			delete(c.fileIDs, h)
+			break
+		}
+	}
+	c.fileIDs[hash] = fileID
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestImageCache(t *testing.T) {
+	c := newImageCache(&imageCacheConfig_t{MaxBytes: 10, TTL: duration_t(time.Minute)})
+
+	a := &image_t{name: "a.png", data: []byte("aaaaaa")}
+	c.put("http://minio/b/a.png", a)
+	c.put("http://minio/b/copy-of-a.png", &image_t{name: "copy-of-a.png", data: []byte("aaaaaa")})
+	if len(c.blobs) != 1 || c.size != 6 {
+		t.Errorf("Same content is kept twice: %d blobs, %d bytes", len(c.blobs), c.size)
+	}
+	if got := c.get("http://minio/b/copy-of-a.png"); got != a {
+		t.Errorf("Expected the image by content, got %+v", got)
+	}
+
+	// a is evicted by size
+	c.put("http://minio/b/b.png", &image_t{name: "b.png", data: []byte("bbbbbb")})
+	if c.get("http://minio/b/a.png") != nil {
+		t.Errorf("a is not evicted")
+	}
+	if c.get("http://minio/b/b.png") == nil {
+		t.Errorf("b is not cached")
+	}
+
+	// Expired
+	c.urls["http://minio/b/b.png"].expires = time.Now().Add(-time.Second)
+	if c.get("http://minio/b/b.png") != nil {
+		t.Errorf("Expired image is returned")
+	}
+
+	var disabled *imageCache_t
+	disabled.put("http://minio/b/a.png", a)
+	if disabled.get("http://minio/b/a.png") != nil || disabled.fileID(a) != "" {
+		t.Errorf("nil cache returns something")
+	}
+}
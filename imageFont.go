@@ -0,0 +1,87 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"log/slog"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Annotation text is drawn by the embedded Go Mono font, it covers Latin, Cyrillic and Greek.
+// fontSize is the font size in pixels at scale 1.
+const fontSize = 10
+
+var monoFont = sync.OnceValues(func() (*opentype.Font, error) {
+	return opentype.Parse(gomono.TTF)
+})
+
+// fontFace returns a new face of the font scaled up by scale. Faces are not safe for concurrent use.
+func fontFace(scale int) font.Face {
+
+	f, err := monoFont()
+	if err == nil {
+		var face font.Face
+		face, err = opentype.NewFace(f, &opentype.FaceOptions{Size: float64(fontSize * scale), DPI: 72, Hinting: font.HintingFull})
+		if err == nil {
+			return face
+		}
+	}
+	slog.Error("imageFont, Go Mono font", "err", err)
+	return nil
+}
+
+// textHeight returns the height of the line drawn by drawText.
+func textHeight(scale int) int {
+	face := fontFace(scale)
+	if face == nil {
+		return 0
+	}
+	defer face.Close()
+	m := face.Metrics()
+	return (m.Ascent + m.Descent).Ceil()
+}
+
+// textWidth returns the width of the text drawn by drawText.
+func textWidth(text string, scale int) int {
+	face := fontFace(scale)
+	if face == nil {
+		return 0
+	}
+	defer face.Close()
+	return font.MeasureString(face, text).Ceil()
+}
+
+// drawText draws the text scaled up by scale, (x, y) is the top left corner.
+// The text is cut at the right border of dst.
+func drawText(dst *image.RGBA, x int, y int, scale int, text string, c color.Color) {
+
+	face := fontFace(scale)
+	if face == nil {
+		return
+	}
+	defer face.Close()
+
+	limit := fixed.I(dst.Bounds().Max.X - x)
+	var width fixed.Int26_6
+	cut := len(text)
+	for i, r := range text {
+		adv, _ := face.GlyphAdvance(r)
+		if width+adv > limit {
+			cut = i
+			break
+		}
+		width += adv
+	}
+	d := font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(c),
+		Face: face,
+		Dot:  fixed.Point26_6{X: fixed.I(x), Y: fixed.I(y) + face.Metrics().Ascent},
+	}
+	d.DrawString(text[:cut])
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"log/slog"
+	"path"
+	"strings"
+	"time"
+)
+
+// Telegram photo limits: width + height, and the ratio of the sides.
+const (
+	telegramMaxDimensions = 10000
+	telegramMaxRatio      = 20
+
+	imageMaxPixels = 100 * 1000 * 1000 // not decoded if bigger
+)
+
+// imageProcess_t fits the image into Telegram photo limits, and optionally annotates it.
+// Set by the "imageProcess" section of WEBHOOK_CONFIG file, the limits are applied without it too.
+// Images in the limits and without annotation are sent as they are.
+type imageProcess_t struct {
+	MaxBytes  int  `json:"maxBytes,omitempty"`  // 10 MB by default
+	MaxWidth  int  `json:"maxWidth,omitempty"`  // 2560 by default
+	MaxHeight int  `json:"maxHeight,omitempty"` // 2560 by default
+	Quality   int  `json:"quality,omitempty"`   // JPEG quality if the image is recompressed, 85 by default
+	Annotate  bool `json:"annotate,omitempty"`  // header with the status color bar, alert name and time
+}
+
+var (
+	annotateBackground = color.RGBA{0x18, 0x1B, 0x1F, 0xFF} // Grafana dark theme
+	annotateText       = color.RGBA{0xD8, 0xD9, 0xDA, 0xFF}
+	statusFiring       = color.RGBA{0xE0, 0x1E, 0x5A, 0xFF}
+	statusResolved     = color.RGBA{0x2E, 0xB6, 0x7D, 0xFF}
+	statusOther        = color.RGBA{0x80, 0x80, 0x80, 0xFF}
+)
+
+func (p *imageProcess_t) init() error {
+
+	if p.MaxBytes == 0 {
+		p.MaxBytes = 10 * 1024 * 1024
+	}
+	if p.MaxWidth == 0 {
+		p.MaxWidth = 2560
+	}
+	if p.MaxHeight == 0 {
+		p.MaxHeight = 2560
+	}
+	if p.Quality == 0 {
+		p.Quality = 85
+	}
+	if p.MaxBytes < 0 || p.MaxWidth < 0 || p.MaxHeight < 0 {
+		return fmt.Errorf("limits should be positive")
+	}
+	if p.Quality < 1 || p.Quality > 100 {
+		return fmt.Errorf("quality %d, should be 1..100", p.Quality)
+	}
+	return nil
+}
+
+// processImage returns the processed image, or the original one if it can not be processed.
+func (a *App) processImage(img *image_t, alert *AlertBody) *image_t {
+
+	p := a.config.ImageProcess
+	if p == nil {
+		p = &imageProcess_t{}
+		p.init()
+	}
+	out, err := p.process(img, alert)
+	if err != nil {
+		slog.Warn("processImage, sending as is", "image", img.name, "err", err)
+		return img
+	}
+	return out
+}
+
+// fitImage scales down and recompresses the image into maxBytes, for the platforms with small payload limits.
+func fitImage(img *image_t, maxBytes int) (*image_t, error) {
+	p := &imageProcess_t{MaxBytes: maxBytes, Quality: 70}
+	if err := p.init(); err != nil {
+		return nil, err
+	}
+	return p.process(img, nil)
+}
+
+func (p *imageProcess_t) fits(w int, h int) bool {
+	return w <= p.MaxWidth && h <= p.MaxHeight && w+h <= telegramMaxDimensions &&
+		w <= telegramMaxRatio*h && h <= telegramMaxRatio*w
+}
+
+func (p *imageProcess_t) process(img *image_t, alert *AlertBody) (*image_t, error) {
+
+	conf, format, err := image.DecodeConfig(bytes.NewReader(img.data))
+	if err != nil {
+		return nil, err
+	}
+	if !p.Annotate && len(img.data) <= p.MaxBytes && p.fits(conf.Width, conf.Height) {
+		return img, nil
+	}
+	if conf.Width*conf.Height > imageMaxPixels {
+		return nil, fmt.Errorf("image %dx%d is too big to process", conf.Width, conf.Height)
+	}
+	src, _, err := image.Decode(bytes.NewReader(img.data))
+	if err != nil {
+		return nil, err
+	}
+	m := image.NewRGBA(image.Rect(0, 0, conf.Width, conf.Height))
+	draw.Draw(m, m.Bounds(), src, src.Bounds().Min, draw.Src)
+
+	if p.Annotate && alert != nil {
+		m = annotate(m, alert)
+	}
+	m = padRatio(m)
+
+	// Scale down into the limits, then more while the encoded image is too big
+	w, h := m.Bounds().Dx(), m.Bounds().Dy()
+	scale := min(1, float64(p.MaxWidth)/float64(w), float64(p.MaxHeight)/float64(h), float64(telegramMaxDimensions)/float64(w+h))
+	for {
+		sw, sh := max(1, int(float64(w)*scale)), max(1, int(float64(h)*scale))
+		scaled := m
+		if sw != w || sh != h {
+			scaled = resize(m, sw, sh)
+		}
+		data, ext, err := p.encode(scaled, format)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) <= p.MaxBytes {
+			slog.Debug("processImage", "image", img.name, "from", fmt.Sprintf("%dx%d %d", conf.Width, conf.Height, len(img.data)),
+				"to", fmt.Sprintf("%dx%d %d", sw, sh, len(data)))
+			name := strings.TrimSuffix(img.name, path.Ext(img.name)) + ext
+			return &image_t{name: name, contentType: imageContentType(name), data: data}, nil
+		}
+		if sw < 200 || sh < 10 {
+			return nil, fmt.Errorf("can not fit the image into %d bytes", p.MaxBytes)
+		}
+		scale *= 0.75
+	}
+}
+
+// encode keeps PNG if it is small enough, otherwise encodes JPEG.
+func (p *imageProcess_t) encode(m *image.RGBA, format string) ([]byte, string, error) {
+
+	var buf bytes.Buffer
+	if format == "png" {
+		enc := png.Encoder{CompressionLevel: png.BestCompression}
+		if err := enc.Encode(&buf, m); err != nil {
+			return nil, "", err
+		}
+		if buf.Len() <= p.MaxBytes {
+			return buf.Bytes(), ".png", nil
+		}
+		buf.Reset()
+	}
+	if err := jpeg.Encode(&buf, m, &jpeg.Options{Quality: p.Quality}); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), ".jpg", nil
+}
+
+// annotate adds the header above the image: status color bar, "[STATUS] alertname" and the alert time.
+func annotate(src *image.RGBA, alert *AlertBody) *image.RGBA {
+
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	scale := 2
+	if w < 400 {
+		scale = 1
+	}
+	bar := 3 * scale
+	pad := 4 * scale
+	header := bar + 2*pad + textHeight(scale)
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h+header))
+	draw.Draw(dst, image.Rect(0, 0, w, header), image.NewUniform(annotateBackground), image.Point{}, draw.Src)
+	draw.Draw(dst, image.Rect(0, 0, w, bar), image.NewUniform(statusColor(alert.Status)), image.Point{}, draw.Src)
+	draw.Draw(dst, image.Rect(0, header, w, h+header), src, src.Bounds().Min, draw.Src)
+
+	title := fmt.Sprintf("[%s] %s", strings.ToUpper(alert.Status), alert.Labels["alertname"])
+	ts := alertTime(alert).Format("2006-01-02 15:04:05 MST")
+	if x := w - pad - textWidth(ts, scale); x > pad+textWidth(title, scale)+fontSize*scale {
+		drawText(dst, x, bar+pad, scale, ts, annotateText)
+	} else {
+		title += "  " + ts
+	}
+	drawText(dst, pad, bar+pad, scale, title, annotateText)
+	return dst
+}
+
+func statusColor(status string) color.RGBA {
+	switch status {
+	case "firing":
+		return statusFiring
+	case "resolved":
+		return statusResolved
+	}
+	return statusOther
+}
+
+// alertTime returns endsAt of the resolved alert, startsAt of the firing one, or now.
+func alertTime(alert *AlertBody) time.Time {
+	ts := alert.StartsAt
+	if alert.Status == "resolved" {
+		ts = alert.EndsAt
+	}
+	t, err := time.Parse(time.RFC3339, ts)
+	if err != nil || t.Year() <= 1 {
+		return time.Now()
+	}
+	return t.Local()
+}
+
+// padRatio extends the short side of the image by the background,
+// so the ratio of the sides is not more than Telegram allows.
+func padRatio(src *image.RGBA) *image.RGBA {
+
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	nw, nh := w, h
+	if w > telegramMaxRatio*h {
+		nh = (w + telegramMaxRatio - 1) / telegramMaxRatio
+	} else if h > telegramMaxRatio*w {
+		nw = (h + telegramMaxRatio - 1) / telegramMaxRatio
+	} else {
+		return src
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, nw, nh))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(annotateBackground), image.Point{}, draw.Src)
+	offset := image.Pt((nw-w)/2, (nh-h)/2)
+	draw.Draw(dst, image.Rectangle{offset, offset.Add(image.Pt(w, h))}, src, src.Bounds().Min, draw.Src)
+	return dst
+}
+
+// resize scales the image down by averaging the source pixels of every destination pixel.
+func resize(src *image.RGBA, w int, h int) *image.RGBA {
+
+	sb := src.Bounds()
+	sw, sh := sb.Dx(), sb.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	for y := 0; y < h; y++ {
+		y0 := y * sh / h
+		y1 := max((y+1)*sh/h, y0+1)
+		for x := 0; x < w; x++ {
+			x0 := x * sw / w
+			x1 := max((x+1)*sw/w, x0+1)
+
+			var r, g, b, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				i := src.PixOffset(sb.Min.X+x0, sb.Min.Y+sy)
+				for sx := x0; sx < x1; sx++ {
+					r += uint32(src.Pix[i])
+					g += uint32(src.Pix[i+1])
+					b += uint32(src.Pix[i+2])
+					a += uint32(src.Pix[i+3])
+					i += 4
+					n++
+				}
+			}
+			j := dst.PixOffset(x, y)
+			dst.Pix[j] = uint8(r / n)
+			dst.Pix[j+1] = uint8(g / n)
+			dst.Pix[j+2] = uint8(b / n)
+			dst.Pix[j+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
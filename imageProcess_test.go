@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func testPNG(t *testing.T, w int, h int) *image_t {
+	m := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			m.Set(x, y, color.RGBA{uint8(x), uint8(y), uint8(x * y), 0xFF})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, m); err != nil {
+		t.Fatal(err)
+	}
+	return &image_t{name: "panel.png", contentType: "image/png", data: buf.Bytes()}
+}
+
+func TestImageProcess(t *testing.T) {
+	alert := &AlertBody{Status: "firing", Labels: map[string]string{"alertname": "CPU"}, StartsAt: "2025-04-27T09:07:50Z"}
+
+	for _, tt := range []struct {
+		name  string
+		p     imageProcess_t
+		w, h  int
+		same  bool
+		check func(w, h int) bool
+	}{
+		{"in limits", imageProcess_t{}, 300, 200, true, nil},
+		{"too wide", imageProcess_t{MaxWidth: 1000}, 2000, 400, false, func(w, h int) bool { return w == 1000 && h == 200 }},
+		{"ratio", imageProcess_t{}, 2000, 50, false, func(w, h int) bool { return w <= telegramMaxRatio*h }},
+		{"annotate", imageProcess_t{Annotate: true}, 500, 200, false, func(w, h int) bool { return w == 500 && h > 200 }},
+		{"too big", imageProcess_t{MaxBytes: 20000}, 600, 600, false, func(w, h int) bool { return w <= 600 }},
+	} {
+		tt.p.init()
+		img := testPNG(t, tt.w, tt.h)
+		out, err := tt.p.process(img, alert)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if tt.same {
+			if out != img {
+				t.Errorf("%s: image is changed", tt.name)
+			}
+			continue
+		}
+		conf, _, err := image.DecodeConfig(bytes.NewReader(out.data))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !tt.check(conf.Width, conf.Height) || len(out.data) > tt.p.MaxBytes {
+			t.Errorf("%s: unexpected result %dx%d %d bytes", tt.name, conf.Width, conf.Height, len(out.data))
+		}
+	}
+}
+
+func TestDrawTextCyrillic(t *testing.T) {
+	draw := func(text string) *image.RGBA {
+		m := image.NewRGBA(image.Rect(0, 0, 100, textHeight(1)))
+		drawText(m, 0, 0, 1, text, color.White)
+		return m
+	}
+	ru, q := draw("Ж"), draw("?")
+	if bytes.Equal(ru.Pix, q.Pix) || bytes.Equal(ru.Pix, draw("").Pix) {
+		t.Error("Cyrillic is not drawn")
+	}
+	if w := textWidth("Диск заполнен", 2); w <= textWidth("Диск", 2) || w == 0 {
+		t.Errorf("Width %d", w)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// renderConfig_t renders the panel by Grafana image renderer (/render/d-solo/...) when the alert has no ImageURL.
+// Set by the "render" section of WEBHOOK_CONFIG file, routes can override the options.
+type renderConfig_t struct {
+	URL   string `json:"url,omitempty"`   // Grafana root URL, taken from panelURL/dashboardURL of the alert if empty
+	Token string `json:"token,omitempty"` // service account token, ${ENV} references are expanded
+	renderOptions_t
+}
+
+type renderOptions_t struct {
+	Disabled bool       `json:"disabled,omitempty"`
+	Width    int        `json:"width,omitempty"`   // 1000 by default
+	Height   int        `json:"height,omitempty"`  // 500 by default
+	Theme    string     `json:"theme,omitempty"`   // "light" or "dark"
+	Timeout  duration_t `json:"timeout,omitempty"` // of one render, 5s by default and at most, see renderMaxTimeout
+	Range    duration_t `json:"range,omitempty"`   // time range before startsAt if the alert has no panelURL, 1h by default
+}
+
+// Panels are rendered inside the webhook request, all the renders of the request share this time, they have to end
+// before the server WriteTimeout (8s) leaving time for sending. Grafana retries the webhook otherwise,
+// and the alert is sent twice. The renders after the deadline are skipped.
+const renderMaxTimeout = 5 * time.Second
+
+var renderClient = &http.Client{Timeout: renderMaxTimeout + time.Second}
+
+// merge returns the options overridden by the non-empty fields of o.
+func (r renderOptions_t) merge(o *renderOptions_t) renderOptions_t {
+	if o == nil {
+		return r
+	}
+	r.Disabled = o.Disabled
+	if o.Width > 0 {
+		r.Width = o.Width
+	}
+	if o.Height > 0 {
+		r.Height = o.Height
+	}
+	if len(o.Theme) > 0 {
+		r.Theme = o.Theme
+	}
+	if o.Timeout > 0 {
+		r.Timeout = o.Timeout
+	}
+	if o.Range > 0 {
+		r.Range = o.Range
+	}
+	return r
+}
+
+// renderOptions returns the options of the first matching route having them, over the global ones.
+// nil - rendering is not configured or disabled.
+func (c *config_t) renderOptions(labels map[string]string) *renderOptions_t {
+
+	if c.Render == nil {
+		return nil
+	}
+	opts := renderOptions_t{Width: 1000, Height: 500, Timeout: duration_t(renderMaxTimeout), Range: duration_t(time.Hour)}
+	opts = opts.merge(&c.Render.renderOptions_t)
+	for _, r := range c.Routes {
+		if r.Render != nil && r.matches(labels) {
+			opts = opts.merge(r.Render)
+			break
+		}
+	}
+	if opts.Disabled {
+		return nil
+	}
+	opts.Timeout = min(opts.Timeout, duration_t(renderMaxTimeout))
+	return &opts
+}
+
+// renderPanel renders the panel of the alert.
+// Dashboard UID and panel ID are taken from __dashboardUid__/__panelId__ annotations or from panelURL.
+// Returns nil if the alert has no panel. ctx is the deadline of all the renders of the request.
+func (a *App) renderPanel(ctx context.Context, alert *AlertBody, opts *renderOptions_t) (*image_t, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("render is skipped: %w", err)
+	}
+	rc := a.config.Render
+
+	var uid, panelID, slug string
+	var base *url.URL
+	q := url.Values{}
+
+	if len(alert.PanelURL) > 0 {
+		u, err := url.Parse(alert.PanelURL)
+		if err != nil {
+			return nil, fmt.Errorf("render, panelURL: %w", err)
+		}
+		base = u
+		// /d/<uid>[/<slug>]
+		parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+		for i := 0; i+1 < len(parts); i++ {
+			if parts[i] == "d" {
+				uid = parts[i+1]
+				if i+2 < len(parts) {
+					slug = parts[i+2]
+				}
+				break
+			}
+		}
+		panelID = u.Query().Get("viewPanel")
+		for _, k := range []string{"orgId", "from", "to"} {
+			if v := u.Query().Get(k); len(v) > 0 {
+				q.Set(k, v)
+			}
+		}
+	} else if len(alert.DashboardURL) > 0 {
+		u, err := url.Parse(alert.DashboardURL)
+		if err == nil {
+			base = u
+		}
+	}
+	if v, ok := alert.Annotations["__dashboardUid__"].(string); ok && len(v) > 0 {
+		uid = v
+	}
+	if v, ok := alert.Annotations["__panelId__"].(string); ok && len(v) > 0 {
+		panelID = v
+	}
+	if len(uid) == 0 || len(panelID) == 0 {
+		return nil, nil
+	}
+
+	root := rc.URL
+	if len(root) == 0 {
+		if base == nil {
+			return nil, fmt.Errorf("render: Grafana URL is unknown, set render.url")
+		}
+		root = base.Scheme + "://" + base.Host
+	}
+	if len(slug) == 0 {
+		slug = "_"
+	}
+
+	// Alert time range, if panelURL has not set it
+	if len(q.Get("from")) == 0 {
+		ts, err := time.Parse(time.RFC3339, alert.StartsAt)
+		if err != nil {
+			ts = time.Now()
+		}
+		to := time.Now()
+		if te, err := time.Parse(time.RFC3339, alert.EndsAt); err == nil && te.Year() > 1 && te.Before(to) {
+			to = te
+		}
+		q.Set("from", strconv.FormatInt(ts.Add(-time.Duration(opts.Range)).UnixMilli(), 10))
+		q.Set("to", strconv.FormatInt(to.UnixMilli(), 10))
+	}
+	if len(q.Get("orgId")) == 0 {
+		q.Set("orgId", "1")
+	}
+	q.Set("panelId", panelID)
+	q.Set("width", strconv.Itoa(opts.Width))
+	q.Set("height", strconv.Itoa(opts.Height))
+	q.Set("timeout", strconv.Itoa(int(time.Duration(opts.Timeout).Seconds())))
+	if len(opts.Theme) > 0 {
+		q.Set("theme", opts.Theme)
+	}
+	if tz := os.Getenv("TZ"); len(tz) > 0 {
+		q.Set("tz", tz)
+	}
+	renderURL := fmt.Sprintf("%s/render/d-solo/%s/%s?%s", strings.TrimSuffix(root, "/"), url.PathEscape(uid), url.PathEscape(slug), q.Encode())
+	slog.Info("renderPanel", "url", renderURL)
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(opts.Timeout))
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, renderURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(rc.Token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+os.ExpandEnv(rc.Token))
+	}
+	resp, err := renderClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("render: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("render: %s", resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "image/png") {
+		return nil, fmt.Errorf("render: Content-Type is %s", ct)
+	}
+	img, err := readImage(uid+"-"+panelID+".png", "image/png", resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("render: %w", err)
+	}
+	return img, nil
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// Max size of a downloaded image.
+const imageMaxSize = 20 * 1024 * 1024
+
+// image_t is the alert image, kept in memory.
+type image_t struct {
+	name        string // base file name, e.g. "QZuBKH4o25RwnGnkXz9G.png"
+	contentType string
+	data        []byte
+	hash        string // content hash, see sum()
+}
+
+// imageFetcher downloads the alert image from ImageURL.
+type imageFetcher interface {
+	fetch(ctx context.Context, u *url.URL) (*image_t, error)
+}
+
+// imageSource_t selects the fetcher for ImageURL. Configured in the "images" section of WEBHOOK_CONFIG file,
+// the first matching source is used. Without a match the image is downloaded from S3/MinIO (MINIO_xxx env).
+type imageSource_t struct {
+	Name   string `json:"name,omitempty"`
+	Scheme string `json:"scheme,omitempty"` // "http", "https", "file", "s3". Empty matches any
+	Host   string `json:"host,omitempty"`   // host or host:port of ImageURL, "*.example.com" matches subdomains. Empty matches any
+	Prefix string `json:"prefix,omitempty"` // ImageURL prefix. Empty matches any
+	Type   string `json:"type"`             // "s3", "http", "file"
+
+	Auth    *forwardAuth_t    `json:"auth,omitempty"`    // http: basic or bearer auth, ${ENV} references are expanded
+	Headers map[string]string `json:"headers,omitempty"` // http: additional headers, ${ENV} references are expanded
+	Timeout duration_t        `json:"timeout,omitempty"` // http: 10s by default
+	Dir     string            `json:"dir,omitempty"`     // file: directory of images, path after Prefix (or file:// path) is taken inside it
+
+	fetcher imageFetcher
+}
+
+func (s *imageSource_t) init(a *App) error {
+
+	switch s.Type {
+	case "s3":
+		s.fetcher = &s3Fetcher_t{myMinio: a.myMinio}
+	case "http":
+		if s.Auth != nil && s.Auth.Type != "basic" && s.Auth.Type != "bearer" {
+			return fmt.Errorf("auth type %q, allowed values are basic, bearer", s.Auth.Type)
+		}
+		if s.Timeout == 0 {
+			s.Timeout = duration_t(10 * time.Second)
+		}
+		s.fetcher = &httpFetcher_t{
+			auth:    s.Auth,
+			headers: s.Headers,
+			client:  &http.Client{Timeout: time.Duration(s.Timeout)},
+		}
+	case "file":
+		if len(s.Dir) == 0 {
+			return fmt.Errorf("dir is not set")
+		}
+		s.fetcher = &fileFetcher_t{dir: s.Dir, prefix: s.Prefix}
+	default:
+		return fmt.Errorf("type %q, allowed values are s3, http, file", s.Type)
+	}
+	return nil
+}
+
+func (s *imageSource_t) matches(imageURL string, u *url.URL) bool {
+
+	if len(s.Scheme) > 0 && !strings.EqualFold(s.Scheme, u.Scheme) {
+		return false
+	}
+	if len(s.Host) > 0 {
+		host := s.Host
+		if strings.HasPrefix(host, "*.") {
+			if !strings.HasSuffix(u.Hostname(), host[1:]) {
+				return false
+			}
+		} else if !strings.EqualFold(host, u.Host) && !strings.EqualFold(host, u.Hostname()) {
+			return false
+		}
+	}
+	if len(s.Prefix) > 0 && !strings.HasPrefix(imageURL, s.Prefix) {
+		return false
+	}
+	return true
+}
+
+// getImage downloads the image of the alert by the matching image source,
+// or renders the panel if the alert has no image, and processes it. Returns nil if there is no image.
+func (a *App) getImage(ctx context.Context, alert *AlertBody) (*image_t, error) {
+
+	img, err := a.downloadImage(ctx, alert)
+	if err != nil || img == nil {
+		return img, err
+	}
+	return a.processImage(img, alert), nil
+}
+
+// Telegram media group limit
+const maxGroupImages = 10
+
+// getImages returns distinct images of the alerts, not more than maxGroupImages.
+// Alerts without image give the rendered panel, if rendering is configured.
+func (a *App) getImages(ctx context.Context, alerts []*AlertBody) []*image_t {
+
+	var images []*image_t
+	seen := map[string]bool{}
+	skipped := 0
+
+	for _, alert := range alerts {
+		key := imageKey(alert)
+		if len(key) == 0 || seen[key] {
+			continue
+		}
+		seen[key] = true
+		if len(images) == maxGroupImages {
+			skipped++
+			continue
+		}
+		img, err := a.getImage(ctx, alert)
+		if err != nil {
+			slog.Error("getImages", "err", err)
+			continue
+		}
+		if img != nil {
+			images = append(images, img)
+		}
+	}
+	if skipped > 0 {
+		slog.Warn("getImages, too many images", "max", maxGroupImages, "skipped", skipped)
+	}
+	return images
+}
+
+// imageKey identifies the image of the alert: image URL, or the panel to render.
+func imageKey(alert *AlertBody) string {
+	if len(alert.ImageURL) > 0 {
+		return alert.ImageURL
+	}
+	uid, _ := alert.Annotations["__dashboardUid__"].(string)
+	panelID, _ := alert.Annotations["__panelId__"].(string)
+	if len(uid) > 0 && len(panelID) > 0 {
+		return "panel:" + uid + "/" + panelID
+	}
+	return alert.PanelURL
+}
+
+func (a *App) downloadImage(ctx context.Context, alert *AlertBody) (*image_t, error) {
+
+	if alert == nil {
+		return nil, nil
+	}
+	if len(alert.ImageURL) == 0 {
+		if opts := a.config.renderOptions(alert.Labels); opts != nil {
+			return a.renderPanel(ctx, alert, opts)
+		}
+		return nil, nil
+	}
+	if img := a.images.get(alert.ImageURL); img != nil {
+		slog.Debug("getImage, cached", "url", alert.ImageURL)
+		return img, nil
+	}
+	u, err := url.Parse(alert.ImageURL)
+	if err != nil {
+		return nil, fmt.Errorf("getImage: %w", err)
+	}
+
+	var fetcher imageFetcher = &s3Fetcher_t{myMinio: a.myMinio}
+	for _, s := range a.config.Images {
+		if s.matches(alert.ImageURL, u) {
+			slog.Debug("getImage", "source", s.Name, "type", s.Type, "url", alert.ImageURL)
+			fetcher = s.fetcher
+			break
+		}
+	}
+	img, err := fetcher.fetch(ctx, u)
+	if err != nil {
+		return nil, fmt.Errorf("getImage %s: %w", alert.ImageURL, err)
+	}
+	a.images.put(alert.ImageURL, img)
+	return img, nil
+}
+
+// readImage reads the image from r, not more than imageMaxSize.
+// Content type is taken from the file name extension if it is not known.
+func readImage(p string, contentType string, r io.Reader) (*image_t, error) {
+
+	data, err := io.ReadAll(io.LimitReader(r, imageMaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > imageMaxSize {
+		return nil, fmt.Errorf("image is bigger than %d bytes", imageMaxSize)
+	}
+	name := path.Base(p)
+	if len(contentType) == 0 || contentType == "application/octet-stream" {
+		contentType = imageContentType(name)
+	}
+	return &image_t{name: name, contentType: contentType, data: data}, nil
+}
+
+// tempFile writes the image into a new private temporary directory, for the commands that need a file.
+// Call the returned function to remove it.
+func (img *image_t) tempFile() (string, func(), error) {
+
+	dir, err := os.MkdirTemp("", "grafana-webhook-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	fileName := filepath.Join(dir, img.name)
+	if err := os.WriteFile(fileName, img.data, 0600); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return fileName, cleanup, nil
+}
+
+// s3Fetcher_t downloads "/<bucket>/<object>" from S3/MinIO server.
+// Host and port of ImageURL are replaced by MINIO_HOST and MINIO_PORT, if set.
+type s3Fetcher_t struct {
+	myMinio *myMinio_t
+}
+
+func (f *s3Fetcher_t) fetch(ctx context.Context, u *url.URL) (*image_t, error) {
+
+	host := u.Hostname()
+	if len(f.myMinio.host) > 0 {
+		host = f.myMinio.host
+	}
+	port := u.Port()
+	if len(f.myMinio.port) > 0 {
+		port = f.myMinio.port
+	}
+	if len(port) > 0 {
+		host = host + ":" + port
+	}
+
+	// Minio client
+	mClient, err := f.myMinio.client(host, u.Scheme)
+	if err != nil {
+		return nil, fmt.Errorf("Minio client create error. Will not send images: %w", err)
+	}
+
+	p := strings.TrimPrefix(u.Path, "/")
+	bucket, object, found := strings.Cut(p, "/")
+	if found == false {
+		return nil, fmt.Errorf("no filename %s", p)
+	}
+
+	// Picture download from Minio
+	ctx, cancel := context.WithTimeout(ctx, minioTimeout)
+	defer cancel()
+	obj, err := mClient.GetObject(ctx, bucket, object, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("image download: %w", err)
+	}
+	defer obj.Close()
+
+	stat, err := obj.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("image download: %w", err)
+	}
+	if stat.Size > imageMaxSize {
+		return nil, fmt.Errorf("image download: image is bigger than %d bytes", imageMaxSize)
+	}
+	img, err := readImage(object, stat.ContentType, obj)
+	if err != nil {
+		return nil, fmt.Errorf("image download: %w", err)
+	}
+	return img, nil
+}
+
+// httpFetcher_t downloads the image by plain HTTP(S) GET.
+type httpFetcher_t struct {
+	auth    *forwardAuth_t
+	headers map[string]string
+	client  *http.Client
+}
+
+func (f *httpFetcher_t) fetch(ctx context.Context, u *url.URL) (*image_t, error) {
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range f.headers {
+		req.Header.Set(k, os.ExpandEnv(v))
+	}
+	if f.auth != nil {
+		switch f.auth.Type {
+		case "basic":
+			req.SetBasicAuth(f.auth.Username, os.ExpandEnv(f.auth.Password))
+		case "bearer":
+			req.Header.Set("Authorization", "Bearer "+os.ExpandEnv(f.auth.Token))
+		}
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("image download: %s", resp.Status)
+	}
+	ct := resp.Header.Get("Content-Type")
+	if len(ct) > 0 && !strings.HasPrefix(ct, "image/") {
+		return nil, fmt.Errorf("image download: Content-Type is %s", ct)
+	}
+	if resp.ContentLength > imageMaxSize {
+		return nil, fmt.Errorf("image download: image is bigger than %d bytes", imageMaxSize)
+	}
+	img, err := readImage(u.Path, ct, resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("image download: %w", err)
+	}
+	return img, nil
+}
+
+// fileFetcher_t copies the image from the local directory, e.g. Grafana local image storage.
+// The path is taken from ImageURL after the prefix, or from file:// URL.
+type fileFetcher_t struct {
+	dir    string
+	prefix string
+}
+
+func (f *fileFetcher_t) fetch(ctx context.Context, u *url.URL) (*image_t, error) {
+
+	rel := u.Path
+	if len(f.prefix) > 0 {
+		rel = strings.TrimPrefix(u.String(), f.prefix)
+		if i := strings.IndexAny(rel, "?#"); i >= 0 {
+			rel = rel[:i]
+		}
+		if unescaped, err := url.PathUnescape(rel); err == nil {
+			rel = unescaped
+		}
+	}
+	// Clean as an absolute path first, ".." can not go above dir
+	src := filepath.Join(f.dir, filepath.Clean("/"+rel))
+
+	in, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	return readImage(src, "", in)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestImageSourceMatch(t *testing.T) {
+	for _, tt := range []struct {
+		source   imageSource_t
+		imageURL string
+		match    bool
+	}{
+		{imageSource_t{Scheme: "https"}, "https://grafana.example.com/a.png", true},
+		{imageSource_t{Scheme: "https"}, "http://minio:9000/b/a.png", false},
+		{imageSource_t{Host: "minio:9000"}, "http://minio:9000/b/a.png", true},
+		{imageSource_t{Host: "minio"}, "http://minio:9000/b/a.png", true},
+		{imageSource_t{Host: "*.example.com"}, "https://grafana.example.com/a.png", true},
+		{imageSource_t{Host: "*.example.com"}, "https://example.org/a.png", false},
+		{imageSource_t{Prefix: "http://grafana:3000/public/"}, "http://grafana:3000/public/img/a.png", true},
+		{imageSource_t{Prefix: "http://grafana:3000/public/"}, "http://grafana:3000/api/a.png", false},
+	} {
+		u, _ := url.Parse(tt.imageURL)
+		if got := tt.source.matches(tt.imageURL, u); got != tt.match {
+			t.Errorf("%+v %s: expected %v", tt.source, tt.imageURL, tt.match)
+		}
+	}
+}
+
+func TestFileFetcher(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "panel-test.png"), []byte("png"), 0600)
+	f := &fileFetcher_t{dir: dir, prefix: "http://grafana:3000/public/img/"}
+
+	u, _ := url.Parse("http://grafana:3000/public/img/panel-test.png")
+	img, err := f.fetch(context.Background(), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(img.data) != "png" || img.name != "panel-test.png" || img.contentType != "image/png" {
+		t.Errorf("Unexpected image %s %s %q", img.name, img.contentType, img.data)
+	}
+
+	// Can not go out of dir
+	u, _ = url.Parse("http://grafana:3000/public/img/../../../etc/passwd")
+	if _, err := f.fetch(context.Background(), u); err == nil {
+		t.Errorf("Expected error for path out of dir")
+	}
+}
+
+func TestRenderPanel(t *testing.T) {
+	var got *http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("png"))
+	}))
+	defer srv.Close()
+
+	app := &App{ctx: context.Background(), config: &config_t{
+		Render: &renderConfig_t{Token: "sa-token", renderOptions_t: renderOptions_t{Theme: "dark", Timeout: duration_t(30 * time.Second)}},
+		Routes: []*route_t{{Match: map[string]string{"team": "db"}, Render: &renderOptions_t{Width: 1600}}},
+	}}
+	alert := &AlertBody{
+		Labels:   map[string]string{"team": "db"},
+		PanelURL: srv.URL + "/d/rYddErhsR/cpu?from=1745744870000&orgId=1&to=1745748500048&viewPanel=3",
+	}
+	img, err := app.getImage(app.ctx, alert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img == nil || string(img.data) != "png" {
+		t.Fatalf("Unexpected image %+v", img)
+	}
+
+	if got == nil {
+		t.Fatal("renderer was not called")
+	}
+	if got.URL.Path != "/render/d-solo/rYddErhsR/cpu" {
+		t.Errorf("Path %s", got.URL.Path)
+	}
+	q := got.URL.Query()
+	for k, v := range map[string]string{"panelId": "3", "from": "1745744870000", "width": "1600", "height": "500", "theme": "dark", "timeout": "5"} {
+		if q.Get(k) != v {
+			t.Errorf("%s=%s, expected %s", k, q.Get(k), v)
+		}
+	}
+	if got.Header.Get("Authorization") != "Bearer sa-token" {
+		t.Errorf("Authorization %q", got.Header.Get("Authorization"))
+	}
+}
+
+func TestS3Fetcher(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Content-Length", "3")
+		w.Header().Set("Last-Modified", "Mon, 28 Apr 2025 10:00:00 GMT")
+		w.Header().Set("ETag", `"e"`)
+		w.Write([]byte("png"))
+	}))
+	defer srv.Close()
+
+	m := &myMinio_t{key: "k", secret: "s", region: "us-east-1", lookup: "path"}
+	if err := m.init(); err != nil {
+		t.Fatal(err)
+	}
+	f := &s3Fetcher_t{myMinio: m}
+	u, _ := url.Parse(srv.URL + "/mybucket/QZuBKH4o25RwnGnkXz9G.png")
+	for i := 0; i < 2; i++ {
+		img, err := f.fetch(context.Background(), u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(img.data) != "png" || img.name != "QZuBKH4o25RwnGnkXz9G.png" {
+			t.Errorf("Unexpected image %s %q", img.name, img.data)
+		}
+	}
+	if len(m.clients) != 1 {
+		t.Errorf("Expected one cached client, got %d", len(m.clients))
+	}
+	if paths[0] != "/mybucket/QZuBKH4o25RwnGnkXz9G.png" {
+		t.Errorf("Path %s", paths[0])
+	}
+
+	for _, bad := range []*myMinio_t{{lookup: "vhost"}, {credentials: "sts"}, {caFile: "/nonexistent"}} {
+		if err := bad.init(); err == nil {
+			t.Errorf("%+v: expected error", bad)
+		}
+	}
+}
+
+func TestRenderDeadline(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		time.Sleep(300 * time.Millisecond)
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("png"))
+	}))
+	defer srv.Close()
+
+	app := &App{ctx: context.Background(), config: &config_t{Render: &renderConfig_t{renderOptions_t: renderOptions_t{Timeout: duration_t(time.Second)}}}}
+	var alerts []*AlertBody
+	for _, panel := range []string{"1", "2", "3"} {
+		alerts = append(alerts, &AlertBody{PanelURL: srv.URL + "/d/uid/cpu?viewPanel=" + panel})
+	}
+	// All the renders of the request share the deadline
+	ctx, cancel := context.WithTimeout(app.ctx, 500*time.Millisecond)
+	defer cancel()
+	if images := app.getImages(ctx, alerts); len(images) != 1 {
+		t.Errorf("%d images rendered", len(images))
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("%d renders, expected the last one skipped", n)
+	}
+}
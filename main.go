@@ -76,6 +76,18 @@ func main() {
 		port:   os.Getenv("MINIO_PORT"),
 		key:    os.Getenv("MINIO_KEY"),
 		secret: os.Getenv("MINIO_SECRET"),
+
+		secure:       os.Getenv("MINIO_SECURE"),
+		caFile:       os.Getenv("MINIO_CA_FILE"),
+		skipVerify:   os.Getenv("MINIO_SKIP_VERIFY") == "true",
+		region:       os.Getenv("MINIO_REGION"),
+		lookup:       os.Getenv("MINIO_LOOKUP"),
+		credentials:  os.Getenv("MINIO_CREDENTIALS"),
+		sessionToken: os.Getenv("MINIO_SESSION_TOKEN"),
+		stsEndpoint:  os.Getenv("MINIO_STS_ENDPOINT"),
+		roleARN:      os.Getenv("MINIO_ROLE_ARN"),
+		tokenFile:    os.Getenv("MINIO_WEB_IDENTITY_TOKEN_FILE"),
+		iamEndpoint:  os.Getenv("MINIO_IAM_ENDPOINT"),
 	}
 
 	// Initialise atClient
@@ -147,6 +159,53 @@ func main() {
 				atClient.timeout = t
 			}
 		}
+
+		// Persistent atclient workers
+		atClient.poolWait = 10 * time.Second
+		atClient.poolHealth = 30 * time.Second
+		atClient.poolArgs = []string{"--serve"}
+
+		env = os.Getenv("ATCLIENT_POOL_SIZE")
+		if len(env) > 0 {
+			n, err := strconv.Atoi(env)
+			if err != nil || n < 0 {
+				slog.Warn("ATCLIENT_POOL_SIZE", "should be non-negative integer", env)
+			} else {
+				atClient.poolSize = n
+			}
+		}
+		atClient.poolQueue = 4 * atClient.poolSize
+		env = os.Getenv("ATCLIENT_POOL_QUEUE")
+		if len(env) > 0 {
+			n, err := strconv.Atoi(env)
+			if err != nil || n < 0 {
+				slog.Warn("ATCLIENT_POOL_QUEUE", "should be non-negative integer", env)
+			} else {
+				atClient.poolQueue = n
+			}
+		}
+		env = os.Getenv("ATCLIENT_POOL_WAIT")
+		if len(env) > 0 {
+			t, err := time.ParseDuration(env)
+			if err != nil {
+				slog.Warn("ATCLIENT_POOL_WAIT", "time.Duration format error", err)
+			} else {
+				atClient.poolWait = t
+			}
+		}
+		env = os.Getenv("ATCLIENT_POOL_HEALTH")
+		if len(env) > 0 {
+			t, err := time.ParseDuration(env)
+			if err != nil || t <= 0 {
+				slog.Warn("ATCLIENT_POOL_HEALTH", "time.Duration format error", env)
+			} else {
+				atClient.poolHealth = t
+			}
+		}
+		env = os.Getenv("ATCLIENT_POOL_ARGS")
+		if len(env) > 0 {
+			atClient.poolArgs = strings.Fields(env)
+		}
 	} else {
 		atClient = nil
 	}
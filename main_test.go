@@ -2,16 +2,25 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 )
 
 var a App
 
+const testAPIToken = "secret"
+
 func TestMain(m *testing.M) {
-	a.Initialize()
+	os.Setenv("WEBHOOK_API_TOKEN", testAPIToken)
+	// ATCLIENT: no Telegram bot API connection is needed for tests
+	if err := a.Initialize(context.Background(), "ATCLIENT", -1, "0", &myMinio_t{}, &atClient_t{javaPath: "java"}); err != nil {
+		panic(err)
+	}
 	code := m.Run()
 	os.Exit(code)
 }
@@ -27,9 +36,40 @@ func TestCreateAlert(t *testing.T) {
 	checkResponseCode(t, http.StatusCreated, response.Code)
 }
 
+// apiRequest returns the API request with the token.
+func apiRequest(method string, url string, body io.Reader) *http.Request {
+	req, _ := http.NewRequest(method, url, body)
+	req.Header.Set("Authorization", "Bearer "+testAPIToken)
+	return req
+}
+
+func TestAPIToken(t *testing.T) {
+	for _, u := range []string{"/maintenance", "/alerts/fp/ack", "/oncall/primary/override"} {
+		req, _ := http.NewRequest("POST", u, nil)
+		checkResponseCode(t, http.StatusUnauthorized, executeRequest(req).Code)
+		req, _ = http.NewRequest("POST", u, nil)
+		req.Header.Set("Authorization", "Bearer wrong")
+		checkResponseCode(t, http.StatusUnauthorized, executeRequest(req).Code)
+	}
+
+	// No token, no API
+	a.apiToken = ""
+	defer func() { a.apiToken = testAPIToken }()
+	for _, req := range []*http.Request{
+		apiRequest("POST", "/maintenance", strings.NewReader(`{"name":"x","duration":"1h"}`)),
+		apiRequest("DELETE", "/maintenance/x", nil),
+		apiRequest("POST", "/alerts/fp/ack", nil),
+		apiRequest("POST", "/oncall/primary/override", nil),
+		apiRequest("DELETE", "/oncall/primary/override", nil),
+	} {
+		req.Header.Set("Authorization", "Bearer ")
+		checkResponseCode(t, http.StatusForbidden, executeRequest(req).Code)
+	}
+}
+
 func executeRequest(req *http.Request) *httptest.ResponseRecorder {
 	rr := httptest.NewRecorder()
-	a.Router.ServeHTTP(rr, req)
+	a.srv.Handler.ServeHTTP(rr, req)
 
 	return rr
 }
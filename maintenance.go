@@ -0,0 +1,527 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// maintenance_t is a window muting the notifications of the matching alerts, independent of Grafana silences.
+// The window is either recurring: Schedule (cron) with Duration, or Weekdays with From-To time of the day;
+// or ad-hoc: Start-End, created by the API. Notifications in the window are dropped,
+// or deferred and sent as one summary when the window ends.
+// Set by the "maintenance" section of WEBHOOK_CONFIG file, and by POST /maintenance.
+type maintenance_t struct {
+	Name     string            `json:"name"`
+	Match    map[string]string `json:"match,omitempty"`    // labels, all of them must match. Empty Match matches any alert
+	Routes   []string          `json:"routes,omitempty"`   // names of the routes, the alert must match one of them
+	Schedule string            `json:"schedule,omitempty"` // cron "minute hour day month weekday" of the window start
+	Duration duration_t        `json:"duration,omitempty"` // of the scheduled window, of the ad-hoc one without End
+	Weekdays []string          `json:"weekdays,omitempty"` // "mon", "tue", ... every day if empty
+	From     string            `json:"from,omitempty"`     // "22:00", the window goes through midnight if To is earlier
+	To       string            `json:"to,omitempty"`       // "06:00"
+	Timezone string            `json:"timezone,omitempty"` // "Europe/Moscow", TZ env by default
+	Start    *time.Time        `json:"start,omitempty"`
+	End      *time.Time        `json:"end,omitempty"`
+	Action   string            `json:"action,omitempty"` // "drop" (default) or "defer"
+	Comment  string            `json:"comment,omitempty"`
+
+	cron     *cron_t
+	loc      *time.Location
+	weekdays uint8 // bit set by time.Weekday
+	from, to int   // minutes of the day
+	adhoc    bool
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+func (w *maintenance_t) init() error {
+
+	if len(w.Name) == 0 {
+		return fmt.Errorf("name is not set")
+	}
+	switch w.Action {
+	case "", "drop", "defer":
+	default:
+		return fmt.Errorf("action %q, should be drop or defer", w.Action)
+	}
+	tz := w.Timezone
+	if len(tz) == 0 {
+		tz = os.Getenv("TZ")
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return fmt.Errorf("timezone: %w", err)
+	}
+	w.loc = loc
+
+	switch {
+	case w.Start != nil || w.End != nil:
+		if w.Start == nil {
+			return fmt.Errorf("start is not set")
+		}
+		if w.End == nil && w.Duration > 0 {
+			end := w.Start.Add(time.Duration(w.Duration))
+			w.End = &end
+		}
+		if w.End == nil || !w.End.After(*w.Start) {
+			return fmt.Errorf("end should be after start")
+		}
+	case len(w.Schedule) > 0:
+		if w.cron, err = parseCron(w.Schedule); err != nil {
+			return err
+		}
+		if w.Duration <= 0 {
+			return fmt.Errorf("duration of the scheduled window is not set")
+		}
+	case len(w.From) > 0 || len(w.To) > 0:
+		if w.from, err = parseTimeOfDay(w.From); err != nil {
+			return fmt.Errorf("from: %w", err)
+		}
+		if w.to, err = parseTimeOfDay(w.To); err != nil {
+			return fmt.Errorf("to: %w", err)
+		}
+		if w.from == w.to {
+			return fmt.Errorf("from and to are the same")
+		}
+		for _, d := range w.Weekdays {
+			wd, ok := weekdayNames[strings.ToLower(d)[:min(3, len(d))]]
+			if !ok {
+				return fmt.Errorf("weekday %q", d)
+			}
+			w.weekdays |= 1 << wd
+		}
+		if len(w.Weekdays) == 0 {
+			w.weekdays = 0x7f
+		}
+	default:
+		return fmt.Errorf("no schedule, from-to or start-end")
+	}
+	return nil
+}
+
+// parseTimeOfDay returns the minutes of "HH:MM".
+func parseTimeOfDay(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("%q, should be HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (w *maintenance_t) deferred() bool {
+	return w.Action == "defer"
+}
+
+// active tells whether the window is on at now, and when it ends.
+func (w *maintenance_t) active(now time.Time) (bool, time.Time) {
+
+	switch {
+	case w.End != nil:
+		return !now.Before(*w.Start) && now.Before(*w.End), *w.End
+
+	case w.cron != nil:
+		// The latest start inside the duration
+		from := now.Add(-time.Duration(w.Duration))
+		for t := now.In(w.loc).Truncate(time.Minute); t.After(from); t = t.Add(-time.Minute) {
+			if w.cron.matches(t) {
+				return true, t.Add(time.Duration(w.Duration))
+			}
+		}
+		return false, time.Time{}
+	}
+
+	t := now.In(w.loc)
+	m := t.Hour()*60 + t.Minute()
+	at := func(days int, minutes int) time.Time {
+		return time.Date(t.Year(), t.Month(), t.Day()+days, 0, minutes, 0, 0, w.loc)
+	}
+	today := w.weekdays&(1<<t.Weekday()) != 0
+	if w.from < w.to {
+		if today && m >= w.from && m < w.to {
+			return true, at(0, w.to)
+		}
+		return false, time.Time{}
+	}
+	// Through midnight
+	if today && m >= w.from {
+		return true, at(1, w.to)
+	}
+	yesterday := w.weekdays&(1<<((t.Weekday()+6)%7)) != 0
+	if yesterday && m < w.to {
+		return true, at(0, w.to)
+	}
+	return false, time.Time{}
+}
+
+// matches tells whether the alert with labels, matching the routes, is in the window.
+func (w *maintenance_t) matches(labels map[string]string, routes []string) bool {
+
+	if !matchLabels(w.Match, labels) {
+		return false
+	}
+	if len(w.Routes) == 0 {
+		return true
+	}
+	for _, r := range w.Routes {
+		for _, rr := range routes {
+			if r == rr {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchedRoutes returns the names of the routes matching labels, in the order of routing.
+func (c *config_t) matchedRoutes(labels map[string]string) []string {
+	var names []string
+	for _, r := range c.Routes {
+		if !r.matches(labels) {
+			continue
+		}
+		names = append(names, r.Name)
+		if !r.Continue {
+			break
+		}
+	}
+	return names
+}
+
+// maintenanceStore_t keeps the windows, the configured and the ad-hoc ones, and the deferred notifications.
+type maintenanceStore_t struct {
+	mu       sync.Mutex
+	windows  []*maintenance_t
+	deferred map[string]*deferred_t // by window name
+	nextID   int
+}
+
+// deferred_t is the notifications deferred by the window, by their targets.
+type deferred_t struct {
+	window *maintenance_t
+	groups map[string]*deferredGroup
+	count  int
+}
+
+type deferredGroup struct {
+	targets []target_t
+	items   []*notification // the last one of the alert
+}
+
+// Max alert lines in the summary.
+const maintenanceMaxLines = 20
+
+func newMaintenanceStore(windows []*maintenance_t) *maintenanceStore_t {
+	return &maintenanceStore_t{windows: windows, deferred: make(map[string]*deferred_t)}
+}
+
+// match returns the active window of the alert, nil if there is none.
+func (s *maintenanceStore_t) match(labels map[string]string, routes []string, now time.Time) *maintenance_t {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, w := range s.windows {
+		if on, _ := w.active(now); on && w.matches(labels, routes) {
+			return w
+		}
+	}
+	return nil
+}
+
+// hold keeps the notification for the summary of the window.
+func (s *maintenanceStore_t) hold(w *maintenance_t, n *notification, targets []target_t) {
+
+	var keys []string
+	for _, t := range targets {
+		keys = append(keys, t.String())
+	}
+	key := strings.Join(keys, ",")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.deferred[w.Name]
+	if !ok {
+		d = &deferred_t{window: w, groups: make(map[string]*deferredGroup)}
+		s.deferred[w.Name] = d
+	}
+	d.count++
+	g, ok := d.groups[key]
+	if !ok {
+		g = &deferredGroup{targets: targets}
+		d.groups[key] = g
+	}
+	for i, it := range g.items {
+		if len(n.fingerprint) > 0 && it.fingerprint == n.fingerprint {
+			g.items = append(g.items[:i], g.items[i+1:]...)
+			break
+		}
+	}
+	g.items = append(g.items, n)
+}
+
+// add creates the ad-hoc window, its name is generated if it is not set.
+func (s *maintenanceStore_t) add(w *maintenance_t) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(w.Name) == 0 {
+		s.nextID++
+		w.Name = fmt.Sprintf("adhoc-%d", s.nextID)
+	}
+	for _, ww := range s.windows {
+		if ww.Name == w.Name {
+			return fmt.Errorf("window %q exists", w.Name)
+		}
+	}
+	w.adhoc = true
+	if err := w.init(); err != nil {
+		return err
+	}
+	s.windows = append(s.windows, w)
+	return nil
+}
+
+// remove ends the ad-hoc window, its deferred notifications are sent by the next run.
+func (s *maintenanceStore_t) remove(name string) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, w := range s.windows {
+		if w.Name != name {
+			continue
+		}
+		if !w.adhoc {
+			return fmt.Errorf("window %q is configured, not ad-hoc", name)
+		}
+		s.windows = append(s.windows[:i], s.windows[i+1:]...)
+		return nil
+	}
+	return errNotFound
+}
+
+// maintenanceSummary is the summary of the deferred notifications and its targets.
+type maintenanceSummary struct {
+	n       *notification
+	targets []target_t
+}
+
+// due returns the summaries of the windows ended, drops the ended ad-hoc windows.
+func (s *maintenanceStore_t) due(now time.Time) []maintenanceSummary {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []maintenanceSummary
+	for name, d := range s.deferred {
+		if on, _ := d.window.active(now); on && s.exists(d.window) {
+			continue
+		}
+		for _, g := range d.groups {
+			due = append(due, maintenanceSummary{n: d.summary(g), targets: g.targets})
+		}
+		delete(s.deferred, name)
+	}
+	windows := s.windows[:0]
+	for _, w := range s.windows {
+		if !w.adhoc || now.Before(*w.End) {
+			windows = append(windows, w)
+		}
+	}
+	s.windows = windows
+	return due
+}
+
+func (s *maintenanceStore_t) exists(w *maintenance_t) bool {
+	for _, ww := range s.windows {
+		if ww == w {
+			return true
+		}
+	}
+	return false
+}
+
+// summary builds the notification of the deferred ones: count header, a line per alert, "+N more".
+func (d *deferred_t) summary(g *deferredGroup) *notification {
+
+	status := "resolved"
+	for _, n := range g.items {
+		if n.status == "firing" {
+			status = "firing"
+		}
+	}
+	var sb strings.Builder
+	sb.WriteString("***** Maintenance *****\n")
+	fmt.Fprintf(&sb, "%s ended, %d notifications deferred\n", d.window.Name, d.count)
+	if len(d.window.Comment) > 0 {
+		sb.WriteString(d.window.Comment + "\n")
+	}
+	sb.WriteString("**********************\n")
+	for i, n := range g.items {
+		if i == maintenanceMaxLines {
+			fmt.Fprintf(&sb, "+%d more\n", len(g.items)-i)
+			break
+		}
+		if len(n.fingerprint) > 0 && n.alert != nil {
+			sb.WriteString(alertLine(n, nil) + "\n")
+		} else {
+			sb.WriteString(n.subject + "\n")
+		}
+	}
+	last := g.items[len(g.items)-1]
+	return &notification{
+		alert:   last.alert,
+		body:    last.body,
+		status:  status,
+		subject: "[MAINTENANCE] " + d.window.Name,
+		msg:     strings.TrimSuffix(sb.String(), "\n"),
+	}
+}
+
+// run sends the summaries of the ended windows.
+func (s *maintenanceStore_t) run(ctx context.Context, deliver func(n *notification, targets []target_t) error) {
+
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, m := range s.due(time.Now()) {
+			if err := deliver(m.n, m.targets); err != nil {
+				slog.Error("Alert-Webhook, maintenance summary error", "subject", m.n.subject, "err", err)
+			}
+		}
+	}
+}
+
+// muted tells whether the notification is in a maintenance window. Deferred ones are kept for the summary.
+func (a *App) muted(n *notification, labels map[string]string, targets []target_t) bool {
+
+	w := a.maintenance.match(labels, a.config.matchedRoutes(labels), time.Now())
+	if w == nil {
+		return false
+	}
+	if w.deferred() {
+		a.maintenance.hold(w, n, targets)
+	}
+	slog.Info("Alert-Webhook. Will not send, maintenance", "window", w.Name, "deferred", w.deferred(), "subject", n.subject)
+	return true
+}
+
+// deliverScheduled delivers the notifications sent by the timers (re-notify, flapping digests),
+// they are dropped in maintenance windows.
+func (a *App) deliverScheduled(n *notification, targets []target_t) error {
+
+	labels := map[string]string{}
+	if n.alert != nil {
+		labels = n.alert.Labels
+	} else if n.body != nil {
+		labels = n.body.CommonLabels
+	}
+	if w := a.maintenance.match(labels, a.config.matchedRoutes(labels), time.Now()); w != nil {
+		slog.Info("Alert-Webhook. Will not send, maintenance", "window", w.Name, "subject", n.subject)
+		return nil
+	}
+	return a.deliver(n, targets)
+}
+
+// maintenanceStatus is the window in GET /maintenance.
+type maintenanceStatus struct {
+	*maintenance_t
+	Active bool       `json:"active"`
+	Until  *time.Time `json:"until,omitempty"`
+	AdHoc  bool       `json:"adhoc"`
+	Held   int        `json:"deferred"`
+}
+
+// list returns the windows and their state.
+func (s *maintenanceStore_t) list(now time.Time) []maintenanceStatus {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := []maintenanceStatus{}
+	for _, w := range s.windows {
+		st := maintenanceStatus{maintenance_t: w, AdHoc: w.adhoc}
+		if on, end := w.active(now); on {
+			st.Active = true
+			st.Until = &end
+		}
+		if d, ok := s.deferred[w.Name]; ok {
+			st.Held = d.count
+		}
+		list = append(list, st)
+	}
+	return list
+}
+
+// Maintenance lists the maintenance windows.
+func (a *App) Maintenance(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, a.maintenance.list(time.Now()))
+}
+
+// MaintenanceAdd creates the ad-hoc window. Start is now if it is not set, End or Duration is required.
+func (a *App) MaintenanceAdd(w http.ResponseWriter, r *http.Request) {
+
+	if !a.authorized(w, r) {
+		return
+	}
+	mw := &maintenance_t{}
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(mw); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"result": "error", "message": "Invalid JSON Format"})
+		return
+	}
+	if len(mw.Schedule) > 0 || len(mw.From) > 0 || len(mw.To) > 0 {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"result": "error", "message": "Recurring windows are set by the config file"})
+		return
+	}
+	if mw.Start == nil {
+		now := time.Now()
+		mw.Start = &now
+	}
+	if err := a.maintenance.add(mw); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"result": "error", "message": err.Error()})
+		return
+	}
+	slog.Info("Maintenance window created", "name", mw.Name, "start", mw.Start, "end", mw.End, "action", mw.Action)
+	respondWithJSON(w, http.StatusCreated, map[string]string{"result": "success", "name": mw.Name})
+}
+
+// MaintenanceDelete ends the ad-hoc window.
+func (a *App) MaintenanceDelete(w http.ResponseWriter, r *http.Request) {
+
+	if !a.authorized(w, r) {
+		return
+	}
+	name := mux.Vars(r)["name"]
+	if err := a.maintenance.remove(name); err != nil {
+		code := http.StatusBadRequest
+		if err == errNotFound {
+			code = http.StatusNotFound
+		}
+		respondWithJSON(w, code, map[string]string{"result": "error", "message": err.Error()})
+		return
+	}
+	slog.Info("Maintenance window deleted", "name", name)
+	respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+}
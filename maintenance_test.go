@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCron(t *testing.T) {
+	c, err := parseCron("*/15 22-23,0-5 * * 1-5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for s, want := range map[string]bool{
+		"2026-10-19T22:30:00Z": true,  // Monday
+		"2026-10-19T22:31:00Z": false, // not */15
+		"2026-10-20T05:45:00Z": true,
+		"2026-10-20T06:00:00Z": false,
+		"2026-10-18T23:00:00Z": false, // Sunday
+	} {
+		tt, _ := time.Parse(time.RFC3339, s)
+		if got := c.matches(tt); got != want {
+			t.Errorf("%s: %v, want %v", s, got, want)
+		}
+	}
+	// Day of month or day of week, 7 is Sunday
+	c, _ = parseCron("0 0 1 * 7")
+	for s, want := range map[string]bool{
+		"2026-10-01T00:00:00Z": true, // Thursday, the 1st
+		"2026-10-18T00:00:00Z": true, // Sunday
+		"2026-10-19T00:00:00Z": false,
+	} {
+		tt, _ := time.Parse(time.RFC3339, s)
+		if got := c.matches(tt); got != want {
+			t.Errorf("%s: %v, want %v", s, got, want)
+		}
+	}
+	for _, bad := range []string{"* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *", "x * * * *"} {
+		if _, err := parseCron(bad); err == nil {
+			t.Errorf("%q is accepted", bad)
+		}
+	}
+}
+
+func TestMaintenanceActive(t *testing.T) {
+	at := func(s string) time.Time {
+		tt, _ := time.Parse(time.RFC3339, s)
+		return tt
+	}
+	night := &maintenance_t{Name: "night", Weekdays: []string{"fri"}, From: "22:00", To: "06:00", Timezone: "Europe/Moscow"}
+	if err := night.init(); err != nil {
+		t.Fatal(err)
+	}
+	for s, want := range map[string]string{
+		"2026-10-23T19:30:00Z": "2026-10-24T03:00:00Z", // Friday 22:30 MSK
+		"2026-10-24T02:59:00Z": "2026-10-24T03:00:00Z", // Saturday 05:59 MSK
+		"2026-10-24T03:00:00Z": "",
+		"2026-10-22T19:30:00Z": "", // Thursday
+		"2026-10-23T18:59:00Z": "",
+	} {
+		on, end := night.active(at(s))
+		if on != (want != "") || (on && !end.Equal(at(want))) {
+			t.Errorf("%s: %v until %s, want %q", s, on, end.UTC(), want)
+		}
+	}
+
+	backup := &maintenance_t{Name: "backup", Schedule: "0 2 * * *", Duration: duration_t(90 * time.Minute), Timezone: "UTC"}
+	if err := backup.init(); err != nil {
+		t.Fatal(err)
+	}
+	if on, end := backup.active(at("2026-10-19T03:00:00Z")); !on || !end.Equal(at("2026-10-19T03:30:00Z")) {
+		t.Errorf("Backup window: %v until %s", on, end)
+	}
+	if on, _ := backup.active(at("2026-10-19T03:30:00Z")); on {
+		t.Error("Backup window is on after its duration")
+	}
+
+	for _, bad := range []*maintenance_t{
+		{Name: "x"},
+		{Name: "x", Schedule: "0 2 * * *"},
+		{Name: "x", From: "22:00", To: "22:00"},
+		{Name: "x", From: "22:00", To: "25:00"},
+		{Name: "x", From: "22:00", To: "06:00", Weekdays: []string{"holiday"}},
+		{Name: "x", From: "22:00", To: "06:00", Action: "mute"},
+	} {
+		if err := bad.init(); err == nil {
+			t.Errorf("%+v is accepted", bad)
+		}
+	}
+}
+
+func TestMaintenanceDefer(t *testing.T) {
+	start := time.Now().Add(-time.Minute)
+	end := time.Now().Add(time.Hour)
+	w := &maintenance_t{Name: "upgrade", Match: map[string]string{"team": "db"}, Routes: []string{"db"}, Start: &start, End: &end, Action: "defer"}
+	s := newMaintenanceStore(nil)
+	if err := s.add(w); err != nil {
+		t.Fatal(err)
+	}
+	if s.match(map[string]string{"team": "db"}, []string{"default"}, time.Now()) != nil {
+		t.Error("Window matches the other route")
+	}
+	if s.match(map[string]string{"team": "web"}, []string{"db"}, time.Now()) != nil {
+		t.Error("Window matches the other labels")
+	}
+	if s.match(map[string]string{"team": "db"}, []string{"db"}, time.Now()) != w {
+		t.Fatal("Window does not match")
+	}
+
+	ops := []target_t{{"telegram", "-100"}}
+	for _, st := range []string{"firing", "resolved", "firing"} {
+		alert := &AlertBody{Labels: map[string]string{"alertname": "Replication", "team": "db"}, Annotations: map[string]interface{}{"summary": "Lag"}}
+		s.hold(w, &notification{alert: alert, fingerprint: "fp1", status: st, subject: "[" + strings.ToUpper(st) + "] Replication"}, ops)
+	}
+	s.hold(w, &notification{status: "resolved", subject: "[RESOLVED:2] group"}, ops)
+
+	if due := s.due(time.Now()); len(due) != 0 {
+		t.Fatalf("Summary is sent while the window is on")
+	}
+	if err := s.remove("upgrade"); err != nil {
+		t.Fatal(err)
+	}
+	due := s.due(time.Now())
+	if len(due) != 1 {
+		t.Fatalf("%d summaries", len(due))
+	}
+	n := due[0].n
+	if n.subject != "[MAINTENANCE] upgrade" || n.status != "firing" {
+		t.Errorf("Summary %q %s", n.subject, n.status)
+	}
+	for _, line := range []string{"upgrade ended, 4 notifications deferred", "[FIRING] Replication {team=db} - Lag", "[RESOLVED:2] group"} {
+		if !strings.Contains(n.msg, line) {
+			t.Errorf("Summary has no %q:\n%s", line, n.msg)
+		}
+	}
+	if strings.Count(n.msg, "Replication") != 1 {
+		t.Errorf("Alert is repeated in the summary:\n%s", n.msg)
+	}
+}
+
+func TestMaintenanceAPI(t *testing.T) {
+	body := `{"name":"api-test","match":{"maintenance_test":"1"},"duration":"1h","comment":"Upgrade"}`
+	req, _ := http.NewRequest("POST", "/maintenance", strings.NewReader(body))
+	checkResponseCode(t, http.StatusUnauthorized, executeRequest(req).Code)
+
+	req = apiRequest("POST", "/maintenance", strings.NewReader(body))
+	checkResponseCode(t, http.StatusCreated, executeRequest(req).Code)
+
+	req, _ = http.NewRequest("POST", "/alert", bytes.NewBufferString(`{"alerts":[{"status":"firing","labels":{"alertname":"T","maintenance_test":"1"}}]}`))
+	checkResponseCode(t, http.StatusCreated, executeRequest(req).Code)
+
+	req, _ = http.NewRequest("GET", "/maintenance", nil)
+	rr := executeRequest(req)
+	var list []map[string]any
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	if len(list) != 1 || list[0]["name"] != "api-test" || list[0]["active"] != true || list[0]["adhoc"] != true {
+		t.Errorf("Windows %s", rr.Body.String())
+	}
+
+	req = apiRequest("DELETE", "/maintenance/api-test", nil)
+	checkResponseCode(t, http.StatusOK, executeRequest(req).Code)
+	req = apiRequest("DELETE", "/maintenance/api-test", nil)
+	checkResponseCode(t, http.StatusNotFound, executeRequest(req).Code)
+}
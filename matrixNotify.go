@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// matrixNotifier_t sends alerts to Matrix rooms by the client-server API.
+// Text messages are sent with HTML formatted body, images are uploaded to the media repository first.
+// Resolve of an alert is sent as an edit (m.replace) of its firing message.
+type matrixNotifier_t struct {
+	ctx        context.Context
+	homeserver string // https://matrix.example.com
+	token      string
+	client     *http.Client
+	txn        atomic.Int64
+
+	mu     sync.Mutex
+	events map[string]matrixEvent_t // fingerprint + room -> the firing message
+}
+
+// matrixEvent_t is the firing message, forgotten after matrixEventTTL if the alert has not resolved.
+type matrixEvent_t struct {
+	id string
+	at time.Time
+}
+
+const matrixEventTTL = 7 * 24 * time.Hour
+
+// newMatrixNotifier returns nil if MATRIX_HOMESERVER env is not set.
+func newMatrixNotifier(ctx context.Context) (*matrixNotifier_t, error) {
+
+	homeserver := strings.TrimSuffix(os.Getenv("MATRIX_HOMESERVER"), "/")
+	if len(homeserver) == 0 {
+		return nil, nil
+	}
+	token := os.Getenv("MATRIX_TOKEN")
+	if len(token) == 0 {
+		return nil, fmt.Errorf("MATRIX_TOKEN env is not set")
+	}
+	m := &matrixNotifier_t{
+		ctx:        ctx,
+		homeserver: homeserver,
+		token:      token,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		events:     make(map[string]matrixEvent_t),
+	}
+	m.txn.Store(time.Now().UnixNano())
+	return m, nil
+}
+
+func (m *matrixNotifier_t) send(n *notification, roomID string) error {
+
+	key := n.fingerprint + "/" + roomID
+
+	if n.fingerprint != "" && n.status == "resolved" {
+		m.mu.Lock()
+		ev, ok := m.events[key]
+		delete(m.events, key)
+		m.mu.Unlock()
+		if ok {
+			return m.sendEdit(roomID, ev.id, n)
+		}
+	}
+
+	if n.image != nil {
+		if err := m.sendImage(roomID, n.image); err != nil {
+			return err
+		}
+	}
+	eventID, err := m.sendEvent(roomID, "m.room.message", matrixText(n))
+	if err != nil {
+		return err
+	}
+	if n.fingerprint != "" && n.status == "firing" {
+		m.remember(key, eventID, time.Now())
+	}
+	return nil
+}
+
+// remember keeps the firing message for the edit on resolve, expired ones are dropped.
+func (m *matrixNotifier_t) remember(key string, eventID string, now time.Time) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for k, ev := range m.events {
+		if now.Sub(ev.at) > matrixEventTTL {
+			delete(m.events, k)
+		}
+	}
+	m.events[key] = matrixEvent_t{id: eventID, at: now}
+}
+
+// matrixText returns m.text content with plain and HTML formatted body.
+// Telegram HTML markup of /notify is the formatted body as it is.
+func matrixText(n *notification) map[string]any {
+
+	lines := strings.Split(n.msg, "\n")
+	for i, l := range lines {
+		if !n.markup {
+			lines[i] = html.EscapeString(l)
+		}
+	}
+	formatted := strings.Join(lines, "<br>")
+	for _, l := range alertLinks(n.alert) {
+		formatted += fmt.Sprintf("<br><a href=\"%s\">%s</a>", html.EscapeString(l[1]), l[0])
+	}
+	return map[string]any{
+		"msgtype":        "m.text",
+		"body":           n.plainMsg(),
+		"format":         "org.matrix.custom.html",
+		"formatted_body": formatted,
+	}
+}
+
+// sendEdit replaces the text of eventID with the new one.
+func (m *matrixNotifier_t) sendEdit(roomID string, eventID string, n *notification) error {
+
+	content := matrixText(n)
+	edit := map[string]any{
+		"msgtype":        "m.text",
+		"body":           "* " + n.plainMsg(),
+		"format":         "org.matrix.custom.html",
+		"formatted_body": "* " + content["formatted_body"].(string),
+		"m.new_content":  content,
+		"m.relates_to": map[string]string{
+			"rel_type": "m.replace",
+			"event_id": eventID,
+		},
+	}
+	_, err := m.sendEvent(roomID, "m.room.message", edit)
+	return err
+}
+
+func (m *matrixNotifier_t) sendImage(roomID string, img *image_t) error {
+
+	data := img.data
+	name := img.name
+	contentType := img.contentType
+
+	// Upload to the media repository
+	u := m.homeserver + "/_matrix/media/v3/upload?filename=" + url.QueryEscape(name)
+	var res struct {
+		ContentURI string `json:"content_uri"`
+	}
+	if err := m.do(http.MethodPost, u, contentType, bytes.NewReader(data), &res); err != nil {
+		return fmt.Errorf("matrix upload: %w", err)
+	}
+
+	_, err := m.sendEvent(roomID, "m.room.message", map[string]any{
+		"msgtype": "m.image",
+		"body":    name,
+		"url":     res.ContentURI,
+		"info": map[string]any{
+			"mimetype": contentType,
+			"size":     len(data),
+		},
+	})
+	return err
+}
+
+// sendEvent sends a room event and returns its ID.
+func (m *matrixNotifier_t) sendEvent(roomID string, eventType string, content map[string]any) (string, error) {
+
+	data, err := json.Marshal(content)
+	if err != nil {
+		return "", err
+	}
+	txnID := strconv.FormatInt(m.txn.Add(1), 10)
+	u := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/%s/%s",
+		m.homeserver, url.PathEscape(roomID), url.PathEscape(eventType), txnID)
+
+	var res struct {
+		EventID string `json:"event_id"`
+	}
+	if err := m.do(http.MethodPut, u, "application/json", bytes.NewReader(data), &res); err != nil {
+		return "", fmt.Errorf("matrix send to %s: %w", roomID, err)
+	}
+	return res.EventID, nil
+}
+
+func (m *matrixNotifier_t) do(method string, u string, contentType string, body io.Reader, res any) error {
+
+	req, err := http.NewRequestWithContext(m.ctx, method, u, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+m.token)
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			ErrCode string `json:"errcode"`
+			Error   string `json:"error"`
+		}
+		json.Unmarshal(respBody, &e)
+		return fmt.Errorf("%s: %s %s", resp.Status, e.ErrCode, e.Error)
+	}
+	return json.Unmarshal(respBody, res)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMatrix is the homeserver recording the requests.
+type fakeMatrix struct {
+	mu     sync.Mutex
+	paths  []string
+	bodies []map[string]any
+}
+
+func (f *fakeMatrix) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, `{"errcode":"M_UNKNOWN_TOKEN","error":"Invalid token"}`)
+		return
+	}
+	data, _ := io.ReadAll(r.Body)
+	body := map[string]any{}
+	json.Unmarshal(data, &body)
+	f.paths = append(f.paths, r.Method+" "+r.URL.Path)
+	f.bodies = append(f.bodies, body)
+
+	if strings.HasPrefix(r.URL.Path, "/_matrix/media/") {
+		io.WriteString(w, `{"content_uri":"mxc://example.com/img"}`)
+		return
+	}
+	fmt.Fprintf(w, `{"event_id":"$event%d"}`, len(f.paths))
+}
+
+func testMatrix(t *testing.T) (*matrixNotifier_t, *fakeMatrix) {
+	f := &fakeMatrix{}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	t.Setenv("MATRIX_HOMESERVER", srv.URL+"/")
+	t.Setenv("MATRIX_TOKEN", "token")
+	m, err := newMatrixNotifier(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, f
+}
+
+func TestMatrixSend(t *testing.T) {
+	m, f := testMatrix(t)
+
+	n := &notification{
+		fingerprint: "fp",
+		status:      "firing",
+		msg:         "[FIRING] CPU\nvalue < 10 & rising",
+		image:       &image_t{name: "panel.png", contentType: "image/png", data: []byte("png")},
+	}
+	if err := m.send(n, "!room:example.com"); err != nil {
+		t.Fatal(err)
+	}
+	resolved := &notification{fingerprint: "fp", status: "resolved", msg: "[RESOLVED] CPU"}
+	if err := m.send(resolved, "!room:example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(f.paths) != 4 || !strings.HasPrefix(f.paths[0], "POST /_matrix/media/v3/upload") ||
+		!strings.HasPrefix(f.paths[1], "PUT /_matrix/client/v3/rooms/!room:example.com/send/m.room.message/") {
+		t.Fatalf("Requests %v", f.paths)
+	}
+	if f.bodies[1]["msgtype"] != "m.image" || f.bodies[1]["url"] != "mxc://example.com/img" {
+		t.Errorf("Image %v", f.bodies[1])
+	}
+	if got := f.bodies[2]["formatted_body"]; got != "[FIRING] CPU<br>value &lt; 10 &amp; rising" {
+		t.Errorf("Formatted %q", got)
+	}
+	relates, _ := f.bodies[3]["m.relates_to"].(map[string]any)
+	if relates["rel_type"] != "m.replace" || relates["event_id"] != "$event3" {
+		t.Errorf("Edit %v", f.bodies[3])
+	}
+	if len(m.events) != 0 {
+		t.Errorf("Events are not forgotten on resolve %v", m.events)
+	}
+}
+
+func TestMatrixEventsExpire(t *testing.T) {
+	m, _ := testMatrix(t)
+
+	now := time.Now()
+	m.remember("old/!room", "$old", now.Add(-matrixEventTTL-time.Minute))
+	m.remember("new/!room", "$new", now)
+	if _, ok := m.events["old/!room"]; ok || len(m.events) != 1 {
+		t.Errorf("Events %v", m.events)
+	}
+}
+
+func TestMatrixMarkup(t *testing.T) {
+	content := matrixText(&notification{msg: "<b>Disk</b> full\n&lt;root&gt;", markup: true})
+	if content["body"] != "Disk full\n<root>" || content["formatted_body"] != "<b>Disk</b> full<br>&lt;root&gt;" {
+		t.Errorf("Content %v", content)
+	}
+	content = matrixText(&notification{msg: "Disk <root>"})
+	if content["body"] != "Disk <root>" || content["formatted_body"] != "Disk &lt;root&gt;" {
+		t.Errorf("Content %v", content)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// init checks the MINIO_xxx parameters, and prepares the transport and credentials shared by the clients.
+func (m *myMinio_t) init() error {
+
+	switch m.secure {
+	case "", "true", "false":
+	default:
+		return fmt.Errorf("MINIO_SECURE %q, allowed values are true, false", m.secure)
+	}
+	switch m.lookup {
+	case "", "auto":
+		m.bucketLookup = minio.BucketLookupAuto
+	case "path":
+		m.bucketLookup = minio.BucketLookupPath
+	case "dns":
+		m.bucketLookup = minio.BucketLookupDNS
+	default:
+		return fmt.Errorf("MINIO_LOOKUP %q, allowed values are auto, path, dns", m.lookup)
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: m.skipVerify}
+	if len(m.caFile) > 0 {
+		pem, err := os.ReadFile(m.caFile)
+		if err != nil {
+			return fmt.Errorf("MINIO_CA_FILE: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("MINIO_CA_FILE %s: no certificates found", m.caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	m.transport = transport
+
+	var err error
+	switch m.credentials {
+	case "", "static":
+		m.creds = credentials.NewStaticV4(m.key, m.secret, m.sessionToken)
+	case "iam":
+		// EC2/ECS metadata, or AWS_WEB_IDENTITY_TOKEN_FILE and AWS_ROLE_ARN env
+		m.creds = credentials.NewIAM(m.iamEndpoint)
+	case "sts":
+		if len(m.stsEndpoint) == 0 {
+			return fmt.Errorf("MINIO_STS_ENDPOINT is not set")
+		}
+		m.creds, err = credentials.NewSTSAssumeRole(m.stsEndpoint, credentials.STSAssumeRoleOptions{
+			AccessKey:    m.key,
+			SecretKey:    m.secret,
+			SessionToken: m.sessionToken,
+			RoleARN:      m.roleARN,
+			Location:     m.region,
+		})
+	case "web-identity":
+		if len(m.stsEndpoint) == 0 || len(m.tokenFile) == 0 {
+			return fmt.Errorf("MINIO_STS_ENDPOINT and MINIO_WEB_IDENTITY_TOKEN_FILE should be set")
+		}
+		tokenFile := m.tokenFile
+		m.creds, err = credentials.NewSTSWebIdentity(m.stsEndpoint, func() (*credentials.WebIdentityToken, error) {
+			token, err := os.ReadFile(tokenFile) // re-read, the token is rotated
+			if err != nil {
+				return nil, err
+			}
+			return &credentials.WebIdentityToken{Token: string(token)}, nil
+		}, func(i *credentials.STSWebIdentity) {
+			i.RoleARN = m.roleARN
+		})
+	default:
+		return fmt.Errorf("MINIO_CREDENTIALS %q, allowed values are static, iam, sts, web-identity", m.credentials)
+	}
+	if err != nil {
+		return fmt.Errorf("MINIO_CREDENTIALS %s: %w", m.credentials, err)
+	}
+	m.clients = make(map[string]*minio.Client)
+	return nil
+}
+
+// client returns the client of the endpoint ("host:port"), one per endpoint is created and reused.
+// HTTPS is used if MINIO_SECURE is true, or if it is not set and the image URL is https.
+func (m *myMinio_t) client(endpoint string, scheme string) (*minio.Client, error) {
+
+	secure := scheme == "https"
+	if len(m.secure) > 0 {
+		secure, _ = strconv.ParseBool(m.secure)
+	}
+	key := endpoint + "|" + strconv.FormatBool(secure)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if c, ok := m.clients[key]; ok {
+		return c, nil
+	}
+	c, err := minio.New(endpoint, &minio.Options{
+		Creds:        m.creds,
+		Secure:       secure,
+		Transport:    m.transport,
+		Region:       m.region,
+		BucketLookup: m.bucketLookup,
+	})
+	if err != nil {
+		return nil, err
+	}
+	m.clients[key] = c
+	return c, nil
+}
+
+// Timeout of the image download from S3/MinIO.
+const minioTimeout = 30 * time.Second
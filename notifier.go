@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"mime"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-telegram/bot/models"
+)
+
+// notification is a formatted alert (or alert group) ready to be sent.
+type notification struct {
+	alert       *AlertBody  // alert for /alert, alert with image (or nil) for /notify
+	body        *Body       // top-level body of alerts
+	fingerprint string      // alert fingerprint for /alert, "" for group notifications
+	status      string      // firing or resolved
+	subject     string      // short one-line title, used by backends having a subject/title field
+	msg         string      // message text, as formatted for Telegram
+	image       *image_t    // nil if there is no image
+	images      []*image_t  // all distinct images of /notify group, images[0] is image. Backends without albums send image only
+	mentions    []mention_t // Telegram users mentioned in group chats
+	dm          bool        // the mentioned users get the notification as a direct message too
+	silent      bool        // Telegram messages without sound
+	markup      bool        // msg is in TELEGRAM_PARSE_MODE markup already (/notify), plain text is escaped for Telegram
+	pin         bool        // pin Telegram message of the alert till it resolves
+
+	ctx context.Context // deadline of the webhook request delivering it, set by deliverTargets
+}
+
+// plainMsg returns msg as plain text for the backends other than Telegram:
+// the tags of TELEGRAM_PARSE_MODE markup are stripped, the entities are unescaped.
+func (n *notification) plainMsg() string {
+	if !n.markup {
+		return n.msg
+	}
+	return html.UnescapeString(htmlTagRe.ReplaceAllString(n.msg, ""))
+}
+
+// notifier is a delivery backend. dest is a backend specific destination: chat ID, e-mail address, etc.
+type notifier interface {
+	send(n *notification, dest string) error
+}
+
+type target_t struct {
+	kind string
+	dest string
+}
+
+func (t target_t) String() string {
+	return t.kind + ":" + t.dest
+}
+
+func parseTarget(s string) (target_t, error) {
+	kind, dest, found := strings.Cut(s, ":")
+	if !found || len(kind) == 0 || len(dest) == 0 {
+		return target_t{}, fmt.Errorf("target %q, should be <kind>:<destination>", s)
+	}
+	return target_t{kind: strings.ToLower(kind), dest: dest}, nil
+}
+
+// alertLinks returns the links of the alert as name -> URL pairs, skipping empty ones.
+func alertLinks(alert *AlertBody) [][2]string {
+	var links [][2]string
+	if alert == nil {
+		return links
+	}
+	for _, l := range [][2]string{
+		{"Source", alert.GeneratorURL},
+		{"Dashboard", alert.DashboardURL},
+		{"Silence", alert.SilenceURL},
+	} {
+		if len(l[1]) > 0 {
+			links = append(links, l)
+		}
+	}
+	return links
+}
+
+func imageContentType(fileName string) string {
+	contentType := mime.TypeByExtension(filepath.Ext(fileName))
+	if len(contentType) == 0 {
+		contentType = "image/png"
+	}
+	return contentType
+}
+
+// telegramNotifier_t sends to Telegram either directly by bot API or by the "atclient" exec notifier.
+type telegramNotifier_t struct {
+	a *App
+}
+
+func (t *telegramNotifier_t) send(n *notification, dest string) error {
+	chatID, err := strconv.ParseInt(dest, 10, 64)
+	if err != nil {
+		return fmt.Errorf("telegram chatID %q: %w", dest, err)
+	}
+	if t.a.bot == nil { // ATCLIENT
+		_, err := t.a.execs["atclient"].run(n, dest)
+		return err
+	} // DIRECT
+	msg := n.msg
+	if t.a.parseMode == models.ParseModeHTML && !n.markup {
+		msg = html.EscapeString(msg) // labels, annotations and values may have <, >, &
+	}
+	ctx := n.ctx
+	if ctx == nil {
+		ctx = t.a.ctx
+	}
+	var mentions []mention_t
+	if chatID < 0 { // group chats only
+		mentions = n.mentions
+	}
+	var m *models.Message
+	if len(n.images) > 1 {
+		m, err = t.a.directTelegramGroup(ctx, chatID, msg, n.images, n.silent, mentions)
+	} else {
+		m, err = t.a.directTelegram(ctx, chatID, msg, n.image, n.silent, mentions)
+	}
+	if err != nil {
+		return err
+	}
+	if n.pin && m != nil {
+		if err := t.a.pinAlert(n, chatID, m.ID); err != nil {
+			slog.Error("telegram, pin error", "chatID", chatID, "err", err)
+		}
+	}
+	return nil
+}
+
+// labelChatID returns Telegram chatID from the "chatID" label, or -1 if there is no correct one.
+func labelChatID(labels map[string]string) int64 {
+	chatID_s, exists := labels["chatID"]
+	if !exists {
+		return -1
+	}
+	chatID, err := strconv.ParseInt(chatID_s, 10, 64)
+	if err != nil {
+		slog.Error("Grafana \"chatID\" Label is incorrect.", "err", err)
+		return -1
+	}
+	return chatID
+}
+
+// labelEmails returns addresses from the "email" label. Several addresses are separated by ',', ';' or space.
+func labelEmails(labels map[string]string) []string {
+	return strings.FieldsFunc(labels["email"], func(c rune) bool {
+		return c == ' ' || c == ',' || c == ';'
+	})
+}
+
+// targets builds the list of delivery targets.
+// labels are used for routing, chatID is the "chatID" label value (-1 if not set), emails are from "email" labels.
+// Targets of the severity level are used if no route matched, then default Telegram chat (TELEGRAM_CHAT_ID)
+// if neither route, severity nor "chatID" label have assigned the alert.
+func (a *App) targets(labels map[string]string, chatID int64, emails []string) []target_t {
+
+	targets, matched := a.config.routeTargets(labels)
+	if _, s := a.config.severityLevel(labels); !matched && s != nil && len(s.targets) > 0 {
+		targets = append(targets, s.targets...) // default route of the severity level
+		matched = true
+	}
+
+	if chatID != -1 {
+		targets = append(targets, target_t{kind: "telegram", dest: strconv.FormatInt(chatID, 10)})
+	} else if !matched && a.chatID != -1 {
+		targets = append(targets, target_t{kind: "telegram", dest: strconv.FormatInt(a.chatID, 10)})
+	}
+	for _, e := range emails {
+		targets = append(targets, target_t{kind: "email", dest: e})
+	}
+	return dedupTargets(targets)
+}
+
+func dedupTargets(targets []target_t) []target_t {
+	seen := make(map[target_t]bool)
+	res := targets[:0]
+	for _, t := range targets {
+		if !seen[t] {
+			seen[t] = true
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+// deliver sends notification to all the targets, errors are collected and returned together.
+func (a *App) deliver(n *notification, targets []target_t) error {
+	_, err := a.deliverTargets(a.ctx, n, targets)
+	return err
+}
+
+// deliverTargets is deliver inside ctx returning the targets the notification is sent to.
+func (a *App) deliverTargets(ctx context.Context, n *notification, targets []target_t) ([]target_t, error) {
+
+	// The notification is kept for re-notify and escalation, the request context is not
+	c := *n
+	c.ctx = ctx
+	n = &c
+
+	var sent []target_t
+	var errs []error
+	for _, t := range targets {
+		nn, ok := a.notifiers[t.kind]
+		if !ok {
+			slog.Error("deliver, no such notifier configured", "target", t.String())
+			errs = append(errs, fmt.Errorf("%s: notifier is not configured", t))
+			continue
+		}
+		slog.Info("deliver. Sending", "target", t.String())
+		if err := nn.send(n, t.dest); err != nil {
+			slog.Error("deliver, send error", "target", t.String(), "err", err)
+			errs = append(errs, fmt.Errorf("%s: %w", t, err))
+			continue
+		}
+		slog.Info("deliver, sent success", "target", t.String())
+		sent = append(sent, t)
+	}
+	a.directMessages(n, targets)
+	return sent, errors.Join(errs...)
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// onCall_t is the rotation of People by daily or weekly shifts from Start, the handover time.
+// Overrides put another person on call for their time, the last matching one wins.
+// Set by the "oncall" section of WEBHOOK_CONFIG file by name, "on-call:<name>" target sends to the person on call.
+// People are mapped to their targets by the "people" section: "alice": "telegram:123456789", a number is Telegram chat ID.
+type onCall_t struct {
+	Rotation  string              `json:"rotation"` // "daily", "weekly"
+	Start     time.Time           `json:"start"`
+	People    []string            `json:"people"`
+	Overrides []*onCallOverride_t `json:"overrides,omitempty"`
+
+	days int // of the shift
+}
+
+type onCallOverride_t struct {
+	Person string    `json:"person"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+}
+
+func (o *onCall_t) init(people map[string]target_t) error {
+
+	switch o.Rotation {
+	case "daily":
+		o.days = 1
+	case "weekly":
+		o.days = 7
+	default:
+		return fmt.Errorf("rotation %q, should be daily or weekly", o.Rotation)
+	}
+	if o.Start.IsZero() {
+		return fmt.Errorf("start is not set")
+	}
+	if len(o.People) == 0 {
+		return fmt.Errorf("no people")
+	}
+	for _, p := range o.People {
+		if _, ok := people[p]; !ok {
+			return fmt.Errorf("%q is not in people", p)
+		}
+	}
+	for i, ov := range o.Overrides {
+		if err := ov.check(people); err != nil {
+			return fmt.Errorf("override %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+func (ov *onCallOverride_t) check(people map[string]target_t) error {
+	if _, ok := people[ov.Person]; !ok {
+		return fmt.Errorf("%q is not in people", ov.Person)
+	}
+	if !ov.End.After(ov.Start) {
+		return fmt.Errorf("end should be after start")
+	}
+	return nil
+}
+
+// parsePerson returns the target of the person, a number is Telegram chat ID.
+func parsePerson(s string) (target_t, error) {
+	if !strings.Contains(s, ":") {
+		s = "telegram:" + s
+	}
+	return parseTarget(s)
+}
+
+// shift returns the person of the rotation on call at now, and the shift time.
+func (o *onCall_t) shift(now time.Time) (string, time.Time, time.Time) {
+
+	at := func(n int) time.Time {
+		return o.Start.AddDate(0, 0, n*o.days) // calendar days, DST safe
+	}
+	n := int(now.Sub(o.Start) / (time.Duration(o.days) * 24 * time.Hour))
+	for at(n).After(now) {
+		n--
+	}
+	for !at(n + 1).After(now) {
+		n++
+	}
+	i := n % len(o.People)
+	if i < 0 {
+		i += len(o.People)
+	}
+	return o.People[i], at(n), at(n + 1)
+}
+
+// onCallShift is the person on call, in GET /oncall.
+type onCallShift struct {
+	Schedule string    `json:"schedule"`
+	Person   string    `json:"person"`
+	Target   string    `json:"target"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Override bool      `json:"override"`
+	Next     string    `json:"next"` // of the rotation
+}
+
+// current returns the person on call at now: the override, or the shift of the rotation.
+func (o *onCall_t) current(now time.Time) onCallShift {
+
+	person, from, to := o.shift(now)
+	next, _, _ := o.shift(to)
+
+	sh := onCallShift{Person: person, From: from, To: to, Next: next}
+	for i := len(o.Overrides) - 1; i >= 0; i-- {
+		ov := o.Overrides[i]
+		if !now.Before(ov.Start) && now.Before(ov.End) {
+			sh.Person, sh.From, sh.To, sh.Override = ov.Person, ov.Start, ov.End, true
+			break
+		}
+	}
+	return sh
+}
+
+// onCallStore_t keeps the schedules and their overrides made by the API.
+type onCallStore_t struct {
+	mu        sync.Mutex
+	schedules map[string]*onCall_t
+	people    map[string]target_t
+}
+
+func newOnCallStore(schedules map[string]*onCall_t, people map[string]target_t) *onCallStore_t {
+	return &onCallStore_t{schedules: schedules, people: people}
+}
+
+// who returns the person on call of the schedule.
+func (s *onCallStore_t) who(schedule string, now time.Time) (onCallShift, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.schedules[schedule]
+	if !ok {
+		return onCallShift{}, errNotFound
+	}
+	sh := o.current(now)
+	sh.Schedule = schedule
+	sh.Target = s.people[sh.Person].String()
+	return sh, nil
+}
+
+// shiftEnd returns the end of the rotation shift of the schedule containing at.
+func (s *onCallStore_t) shiftEnd(schedule string, at time.Time) (time.Time, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.schedules[schedule]
+	if !ok {
+		return time.Time{}, errNotFound
+	}
+	_, _, end := o.shift(at)
+	return end, nil
+}
+
+// list returns the persons on call of all the schedules.
+func (s *onCallStore_t) list(now time.Time) []onCallShift {
+
+	s.mu.Lock()
+	var names []string
+	for name := range s.schedules {
+		names = append(names, name)
+	}
+	s.mu.Unlock()
+	sort.Strings(names)
+
+	list := []onCallShift{}
+	for _, name := range names {
+		if sh, err := s.who(name, now); err == nil {
+			list = append(list, sh)
+		}
+	}
+	return list
+}
+
+// override puts the person on call of the schedule for the override time, expired overrides are dropped.
+func (s *onCallStore_t) override(schedule string, ov *onCallOverride_t) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.schedules[schedule]
+	if !ok {
+		return errNotFound
+	}
+	if err := ov.check(s.people); err != nil {
+		return err
+	}
+	now := time.Now()
+	overrides := []*onCallOverride_t{}
+	for _, old := range o.Overrides {
+		if now.Before(old.End) {
+			overrides = append(overrides, old)
+		}
+	}
+	o.Overrides = append(overrides, ov)
+	return nil
+}
+
+// cancel removes the overrides of the schedule active at now.
+func (s *onCallStore_t) cancel(schedule string, now time.Time) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.schedules[schedule]
+	if !ok {
+		return errNotFound
+	}
+	overrides := []*onCallOverride_t{}
+	for _, ov := range o.Overrides {
+		if now.Before(ov.Start) || !now.Before(ov.End) {
+			overrides = append(overrides, ov)
+		}
+	}
+	o.Overrides = overrides
+	return nil
+}
+
+// onCallNotifier_t sends to the target of the person on call, dest is the schedule name.
+type onCallNotifier_t struct {
+	a *App
+}
+
+func (o *onCallNotifier_t) send(n *notification, dest string) error {
+
+	sh, err := o.a.onCall.who(dest, time.Now())
+	if err != nil {
+		return fmt.Errorf("on-call schedule %q: %w", dest, err)
+	}
+	t, _ := parseTarget(sh.Target)
+	nn, ok := o.a.notifiers[t.kind]
+	if !ok || t.kind == "on-call" {
+		return fmt.Errorf("%s: notifier is not configured", t)
+	}
+	slog.Info("deliver. On call", "schedule", dest, "person", sh.Person, "target", sh.Target)
+	return nn.send(n, t.dest)
+}
+
+// OnCall returns the persons on call, of all the schedules or of {schedule}.
+func (a *App) OnCall(w http.ResponseWriter, r *http.Request) {
+
+	schedule, ok := mux.Vars(r)["schedule"]
+	if !ok {
+		respondWithJSON(w, http.StatusOK, a.onCall.list(time.Now()))
+		return
+	}
+	sh, err := a.onCall.who(schedule, time.Now())
+	if err != nil {
+		respondWithJSON(w, http.StatusNotFound, map[string]string{"result": "error", "message": err.Error()})
+		return
+	}
+	respondWithJSON(w, http.StatusOK, sh)
+}
+
+// OnCallOverride puts {"person": "carol"} on call of the schedule, from "start" (now by default)
+// till "end" or for "duration" (the end of the shift containing start by default).
+func (a *App) OnCallOverride(w http.ResponseWriter, r *http.Request) {
+
+	if !a.authorized(w, r) {
+		return
+	}
+	var req struct {
+		Person   string     `json:"person"`
+		Start    *time.Time `json:"start"`
+		End      *time.Time `json:"end"`
+		Duration duration_t `json:"duration"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"result": "error", "message": "Invalid JSON Format"})
+		return
+	}
+	schedule := mux.Vars(r)["schedule"]
+	ov := &onCallOverride_t{Person: req.Person, Start: time.Now()}
+	if req.Start != nil {
+		ov.Start = *req.Start
+	}
+	end, err := a.onCall.shiftEnd(schedule, ov.Start)
+	if err != nil {
+		respondWithJSON(w, http.StatusNotFound, map[string]string{"result": "error", "message": err.Error()})
+		return
+	}
+	ov.End = end
+	if req.End != nil {
+		ov.End = *req.End
+	} else if req.Duration > 0 {
+		ov.End = ov.Start.Add(time.Duration(req.Duration))
+	}
+	if err := a.onCall.override(schedule, ov); err != nil {
+		respondWithJSON(w, http.StatusBadRequest, map[string]string{"result": "error", "message": err.Error()})
+		return
+	}
+	slog.Info("On-call override", "schedule", schedule, "person", ov.Person, "start", ov.Start, "end", ov.End)
+	respondWithJSON(w, http.StatusCreated, map[string]string{"result": "success"})
+}
+
+// OnCallCancel removes the current override of the schedule.
+func (a *App) OnCallCancel(w http.ResponseWriter, r *http.Request) {
+
+	if !a.authorized(w, r) {
+		return
+	}
+	schedule := mux.Vars(r)["schedule"]
+	if err := a.onCall.cancel(schedule, time.Now()); err != nil {
+		respondWithJSON(w, http.StatusNotFound, map[string]string{"result": "error", "message": err.Error()})
+		return
+	}
+	slog.Info("On-call override canceled", "schedule", schedule)
+	respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordNotifier_t records the destinations it sends to.
+type recordNotifier_t struct {
+	dests []string
+}
+
+func (r *recordNotifier_t) send(n *notification, dest string) error {
+	r.dests = append(r.dests, dest)
+	return nil
+}
+
+func testOnCall(t *testing.T) (*onCall_t, map[string]target_t) {
+	people := map[string]target_t{}
+	for name, p := range map[string]string{"alice": "111", "bob": "telegram:222", "carol": "email:carol@example.com"} {
+		tt, err := parsePerson(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		people[name] = tt
+	}
+	loc, _ := time.LoadLocation("Europe/Berlin")
+	o := &onCall_t{Rotation: "weekly", Start: time.Date(2026, 10, 5, 9, 0, 0, 0, loc), People: []string{"alice", "bob"}}
+	if err := o.init(people); err != nil {
+		t.Fatal(err)
+	}
+	return o, people
+}
+
+func TestOnCallShift(t *testing.T) {
+	o, _ := testOnCall(t)
+	loc := o.Start.Location()
+
+	for _, c := range []struct {
+		at     time.Time
+		person string
+		from   time.Time
+	}{
+		{time.Date(2026, 10, 5, 9, 0, 0, 0, loc), "alice", time.Date(2026, 10, 5, 9, 0, 0, 0, loc)},
+		{time.Date(2026, 10, 12, 8, 59, 0, 0, loc), "alice", time.Date(2026, 10, 5, 9, 0, 0, 0, loc)},
+		{time.Date(2026, 10, 12, 9, 0, 0, 0, loc), "bob", time.Date(2026, 10, 12, 9, 0, 0, 0, loc)},
+		// Over the DST change of October 25, the handover stays at 09:00
+		{time.Date(2026, 10, 26, 9, 30, 0, 0, loc), "bob", time.Date(2026, 10, 26, 9, 0, 0, 0, loc)},
+		// Before the start
+		{time.Date(2026, 10, 1, 0, 0, 0, 0, loc), "bob", time.Date(2026, 9, 28, 9, 0, 0, 0, loc)},
+	} {
+		person, from, to := o.shift(c.at)
+		if person != c.person || !from.Equal(c.from) || !to.Equal(from.AddDate(0, 0, 7)) {
+			t.Errorf("%s: %s %s - %s, want %s from %s", c.at, person, from, to, c.person, c.from)
+		}
+	}
+
+	now := time.Date(2026, 10, 6, 12, 0, 0, 0, loc)
+	o.Overrides = []*onCallOverride_t{{Person: "carol", Start: now.Add(-time.Hour), End: now.Add(time.Hour)}}
+	if sh := o.current(now); sh.Person != "carol" || !sh.Override || sh.Next != "bob" {
+		t.Errorf("Override %+v", sh)
+	}
+	if sh := o.current(now.Add(2 * time.Hour)); sh.Person != "alice" || sh.Override {
+		t.Errorf("After the override %+v", sh)
+	}
+
+	for _, bad := range []*onCall_t{
+		{Rotation: "monthly", Start: now, People: []string{"alice"}},
+		{Rotation: "daily", People: []string{"alice"}},
+		{Rotation: "daily", Start: now, People: []string{"dave"}},
+		{Rotation: "daily", Start: now, People: []string{"alice"}, Overrides: []*onCallOverride_t{{Person: "bob", Start: now, End: now}}},
+	} {
+		if err := bad.init(map[string]target_t{"alice": {"telegram", "1"}, "bob": {"telegram", "2"}}); err == nil {
+			t.Errorf("%+v is accepted", bad)
+		}
+	}
+}
+
+func TestOnCallNotifier(t *testing.T) {
+	o, people := testOnCall(t)
+	rec := &recordNotifier_t{}
+	app := &App{onCall: newOnCallStore(map[string]*onCall_t{"primary": o}, people)}
+	app.notifiers = map[string]notifier{"telegram": rec, "email": rec, "on-call": &onCallNotifier_t{a: app}}
+
+	ops := []target_t{{"telegram", "-100"}, {"on-call", "primary"}}
+	if err := app.deliver(&notification{msg: "Alert"}, ops); err != nil {
+		t.Fatal(err)
+	}
+	sh, _ := app.onCall.who("primary", time.Now())
+	want := strings.TrimPrefix(sh.Target, "telegram:")
+	if len(rec.dests) != 2 || rec.dests[1] != want {
+		t.Errorf("Sent to %v, on call %s", rec.dests, sh.Target)
+	}
+	if err := app.deliver(&notification{msg: "Alert"}, []target_t{{"on-call", "secondary"}}); err == nil {
+		t.Error("Unknown schedule is not an error")
+	}
+}
+
+func TestOnCallAPI(t *testing.T) {
+	o, people := testOnCall(t)
+	saved := a.onCall
+	a.onCall = newOnCallStore(map[string]*onCall_t{"primary": o}, people)
+	defer func() { a.onCall = saved }()
+
+	req := apiRequest("POST", "/oncall/primary/override", strings.NewReader(`{"person":"carol","duration":"2h"}`))
+	checkResponseCode(t, http.StatusCreated, executeRequest(req).Code)
+	// Future start without end: till the end of the shift containing start
+	start := time.Now().Add(10 * 24 * time.Hour)
+	body := fmt.Sprintf(`{"person":"carol","start":%q}`, start.Format(time.RFC3339))
+	req = apiRequest("POST", "/oncall/primary/override", strings.NewReader(body))
+	checkResponseCode(t, http.StatusCreated, executeRequest(req).Code)
+	if _, _, end := o.shift(start); !o.Overrides[len(o.Overrides)-1].End.Equal(end) {
+		t.Errorf("Override end %v, expected %v", o.Overrides[len(o.Overrides)-1].End, end)
+	}
+	req = apiRequest("POST", "/oncall/primary/override", strings.NewReader(`{"person":"dave"}`))
+	checkResponseCode(t, http.StatusBadRequest, executeRequest(req).Code)
+	req = apiRequest("POST", "/oncall/secondary/override", strings.NewReader(`{"person":"carol"}`))
+	checkResponseCode(t, http.StatusNotFound, executeRequest(req).Code)
+
+	req, _ = http.NewRequest("GET", "/oncall/primary", nil)
+	rr := executeRequest(req)
+	var sh onCallShift
+	json.Unmarshal(rr.Body.Bytes(), &sh)
+	if sh.Person != "carol" || sh.Target != "email:carol@example.com" || !sh.Override {
+		t.Errorf("On call %s", rr.Body.String())
+	}
+
+	req = apiRequest("DELETE", "/oncall/primary/override", nil)
+	checkResponseCode(t, http.StatusOK, executeRequest(req).Code)
+	req, _ = http.NewRequest("GET", "/oncall", nil)
+	rr = executeRequest(req)
+	var list []onCallShift
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	if len(list) != 1 || list[0].Override {
+		t.Errorf("On call %s", rr.Body.String())
+	}
+}
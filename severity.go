@@ -0,0 +1,81 @@
+package main
+
+import (
+	"html"
+	"strings"
+)
+
+// severity_t is the formatting and delivery of the alerts by the level of their "severity" label.
+// Set by the "severity" section of WEBHOOK_CONFIG file by level: "critical", "warning", "info", ...
+type severity_t struct {
+	Emoji   string   `json:"emoji,omitempty"`   // before the header
+	Header  string   `json:"header,omitempty"`  // the first line of the message, the level in upper case by default
+	Silent  bool     `json:"silent,omitempty"`  // Telegram messages without sound
+	Pin     bool     `json:"pin,omitempty"`     // pin Telegram message of the firing alert till it resolves
+	Targets []string `json:"targets,omitempty"` // default route of the level: targets of the alerts not matching any route
+
+	targets []target_t
+}
+
+func (s *severity_t) init() error {
+	s.targets = nil
+	for _, t := range s.Targets {
+		tt, err := parseTarget(t)
+		if err != nil {
+			return err
+		}
+		s.targets = append(s.targets, tt)
+	}
+	return nil
+}
+
+// severityRanks order the levels for the batches mixing them, unknown levels are the least severe.
+var severityRanks = map[string]int{"critical": 5, "high": 4, "error": 4, "warning": 3, "medium": 3, "low": 2, "info": 1}
+
+// mostSevere returns the labels of the most severe alert, the first one of the same level.
+func (c *config_t) mostSevere(alerts []*AlertBody) map[string]string {
+
+	var labels map[string]string
+	rank := -1
+	for _, alert := range alerts {
+		if alert == nil {
+			continue
+		}
+		level, _ := c.severityLevel(alert.Labels)
+		if r := severityRanks[level]; r > rank {
+			labels, rank = alert.Labels, r
+		}
+	}
+	return labels
+}
+
+// severityLevel returns the level of the "severity" label, nil if it is not configured.
+func (c *config_t) severityLevel(labels map[string]string) (string, *severity_t) {
+	level := strings.ToLower(labels["severity"])
+	if len(level) == 0 {
+		return "", nil
+	}
+	return level, c.Severity[level]
+}
+
+// applySeverity puts the header of the level to the message, sets silent and pin.
+func (c *config_t) applySeverity(n *notification, labels map[string]string) {
+
+	level, s := c.severityLevel(labels)
+	if s == nil {
+		return
+	}
+	header := s.Header
+	if len(header) == 0 {
+		header = strings.ToUpper(level)
+	}
+	if len(s.Emoji) > 0 {
+		header = s.Emoji + " " + header
+	}
+	if n.markup {
+		header = html.EscapeString(header)
+	}
+	n.msg = header + "\n" + n.msg
+	n.silent = s.Silent
+	n.pin = s.Pin && n.status == "firing" && len(n.fingerprint) > 0 // /alert only, unpinned on resolve
+}
@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+func TestSeverity(t *testing.T) {
+	c := &config_t{Severity: map[string]*severity_t{
+		"critical": {Emoji: "🔥", Pin: true, Targets: []string{"telegram:-200"}},
+		"info":     {Header: "Info", Silent: true},
+	}}
+	for _, s := range c.Severity {
+		if err := s.init(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n := &notification{fingerprint: "fp", status: "firing", msg: "Alert"}
+	c.applySeverity(n, map[string]string{"severity": "Critical"})
+	if n.msg != "🔥 CRITICAL\nAlert" || !n.pin || n.silent {
+		t.Errorf("Critical %q pin %v silent %v", n.msg, n.pin, n.silent)
+	}
+	n = &notification{fingerprint: "fp", status: "resolved", msg: "Alert"}
+	c.applySeverity(n, map[string]string{"severity": "critical"})
+	if n.pin {
+		t.Error("Resolved alert is pinned")
+	}
+	n = &notification{status: "firing", msg: "Alert"}
+	c.applySeverity(n, map[string]string{"severity": "critical"})
+	if n.pin {
+		t.Error("Group notification is pinned")
+	}
+	n = &notification{status: "firing", msg: "Alert"}
+	c.applySeverity(n, map[string]string{"severity": "info"})
+	if n.msg != "Info\nAlert" || n.pin || !n.silent {
+		t.Errorf("Info %q pin %v silent %v", n.msg, n.pin, n.silent)
+	}
+	n = &notification{status: "firing", msg: "Alert"}
+	c.applySeverity(n, map[string]string{"severity": "warning"})
+	if n.msg != "Alert" {
+		t.Errorf("Not configured level %q", n.msg)
+	}
+
+	a := &App{config: c, chatID: -100}
+	if got := a.targets(map[string]string{"severity": "critical"}, -1, nil); len(got) != 1 || got[0].dest != "-200" {
+		t.Errorf("Critical targets %v", got)
+	}
+	if got := a.targets(map[string]string{"severity": "info"}, -1, nil); len(got) != 1 || got[0].dest != "-100" {
+		t.Errorf("Info targets %v", got)
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-telegram/bot"
+)
+
+// Retries of the call rejected by Telegram with 429 Too Many Requests.
+const telegramMaxRetries = 3
+
+// tokenBucket_t allows rate calls per second with burst.
+type tokenBucket_t struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	until  time.Time // blocked by retry_after
+}
+
+func newTokenBucket(rate float64, burst float64) *tokenBucket_t {
+	return &tokenBucket_t{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// reserve takes n tokens and returns how long to wait for them.
+func (b *tokenBucket_t) reserve(n float64, now time.Time) time.Duration {
+
+	if now.After(b.last) {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+	b.tokens -= n
+
+	var wait time.Duration
+	if b.tokens < 0 {
+		wait = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	if blocked := b.until.Sub(now); blocked > wait {
+		wait = blocked
+	}
+	return wait
+}
+
+// telegramLimiter_t keeps the calls of the bot in the Telegram limits: global rate, and the rate per chat,
+// different for groups (negative chat ID) and private chats. 429 responses are retried after retry_after.
+// Set by TELEGRAM_RATE (messages per second, 30 by default), TELEGRAM_GROUP_RATE (messages per minute in a group, 20),
+// TELEGRAM_CHAT_RATE (messages per second in a private chat, 1), TELEGRAM_MAX_RETRY_WAIT (60s) env.
+type telegramLimiter_t struct {
+	mu           sync.Mutex
+	global       *tokenBucket_t
+	chats        map[int64]*tokenBucket_t
+	groupRate    float64 // per second
+	chatRate     float64
+	maxRetryWait time.Duration
+
+	// Metrics
+	calls       map[string]int64 // by result: "ok", "error"
+	rateLimited int64            // 429 responses
+	throttled   int64            // calls delayed by the limiter
+	throttleSec float64          // total delay
+	waiting     int64            // calls waiting now
+}
+
+func newTelegramLimiter() (*telegramLimiter_t, error) {
+
+	rate, err := envFloat("TELEGRAM_RATE", 30)
+	if err != nil {
+		return nil, err
+	}
+	groupRate, err := envFloat("TELEGRAM_GROUP_RATE", 20)
+	if err != nil {
+		return nil, err
+	}
+	chatRate, err := envFloat("TELEGRAM_CHAT_RATE", 1)
+	if err != nil {
+		return nil, err
+	}
+	maxRetryWait := 60 * time.Second
+	if env := os.Getenv("TELEGRAM_MAX_RETRY_WAIT"); len(env) > 0 {
+		maxRetryWait, err = time.ParseDuration(env)
+		if err != nil {
+			return nil, fmt.Errorf("TELEGRAM_MAX_RETRY_WAIT: %w", err)
+		}
+	}
+	return &telegramLimiter_t{
+		global:       newTokenBucket(rate, rate),
+		chats:        make(map[int64]*tokenBucket_t),
+		groupRate:    groupRate / 60,
+		chatRate:     chatRate,
+		maxRetryWait: maxRetryWait,
+		calls:        make(map[string]int64),
+	}, nil
+}
+
+func envFloat(name string, def float64) (float64, error) {
+	env := os.Getenv(name)
+	if len(env) == 0 {
+		return def, nil
+	}
+	v, err := strconv.ParseFloat(env, 64)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("%s %q, should be a positive number", name, env)
+	}
+	return v, nil
+}
+
+// reserve takes n messages from the chat and the global buckets, returns how long to wait.
+func (l *telegramLimiter_t) reserve(chatID int64, n int) time.Duration {
+
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.chats[chatID]
+	if !ok {
+		if len(l.chats) > 1000 {
+			l.prune(now)
+		}
+		if chatID < 0 {
+			b = newTokenBucket(l.groupRate, max(1, l.groupRate*60)) // the minute limit as burst
+		} else {
+			b = newTokenBucket(l.chatRate, max(1, l.chatRate))
+		}
+		l.chats[chatID] = b
+	}
+	wait := b.reserve(float64(n), now)
+	if g := l.global.reserve(float64(n), now); g > wait {
+		wait = g
+	}
+	return wait
+}
+
+// release returns n messages not sent to the chat and the global buckets.
+func (l *telegramLimiter_t) release(chatID int64, n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if b, ok := l.chats[chatID]; ok {
+		b.tokens += float64(n)
+	}
+	l.global.tokens += float64(n)
+}
+
+// prune drops the buckets of the chats idle long enough to be full again.
+func (l *telegramLimiter_t) prune(now time.Time) {
+	for id, b := range l.chats {
+		if now.Sub(b.last) > time.Hour && now.After(b.until) {
+			delete(l.chats, id)
+		}
+	}
+}
+
+// block holds the chat for retry_after.
+func (l *telegramLimiter_t) block(chatID int64, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rateLimited++
+	if b, ok := l.chats[chatID]; ok {
+		b.until = time.Now().Add(d)
+	}
+}
+
+func (l *telegramLimiter_t) sleep(ctx context.Context, d time.Duration) error {
+
+	if d <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	l.throttled++
+	l.throttleSec += d.Seconds()
+	l.waiting++
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		l.waiting--
+		l.mu.Unlock()
+	}()
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// do calls the bot API for n messages to the chat inside the limits, retrying 429 responses.
+// nil limiter just calls.
+func (l *telegramLimiter_t) do(ctx context.Context, chatID int64, n int, call func() error) error {
+
+	if l == nil {
+		return call()
+	}
+	for attempt := 0; ; attempt++ {
+		wait := l.reserve(chatID, n)
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			l.release(chatID, n)
+			l.mu.Lock()
+			l.calls["error"]++
+			l.mu.Unlock()
+			return fmt.Errorf("%w: Telegram rate limit wait %s is past the request deadline", context.DeadlineExceeded, wait.Round(time.Second))
+		}
+		if err := l.sleep(ctx, wait); err != nil {
+			return err
+		}
+		err := call()
+
+		var tooMany *bot.TooManyRequestsError
+		if errors.As(err, &tooMany) && attempt < telegramMaxRetries {
+			wait := time.Duration(tooMany.RetryAfter) * time.Second
+			if wait <= l.maxRetryWait {
+				slog.Warn("Telegram rate limit, retrying", "chatID", chatID, "retry_after", tooMany.RetryAfter)
+				l.block(chatID, wait)
+				continue
+			}
+		}
+		l.mu.Lock()
+		if err != nil {
+			l.calls["error"]++
+		} else {
+			l.calls["ok"]++
+		}
+		l.mu.Unlock()
+		return err
+	}
+}
+
+// writeMetrics writes the limiter state in Prometheus text format.
+func (l *telegramLimiter_t) writeMetrics(w io.Writer) {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP grafana_webhook_telegram_calls_total Telegram bot API calls by result.\n")
+	fmt.Fprintf(w, "# TYPE grafana_webhook_telegram_calls_total counter\n")
+	for _, result := range []string{"ok", "error"} {
+		fmt.Fprintf(w, "grafana_webhook_telegram_calls_total{result=%q} %d\n", result, l.calls[result])
+	}
+	fmt.Fprintf(w, "# HELP grafana_webhook_telegram_rate_limited_total 429 Too Many Requests responses.\n")
+	fmt.Fprintf(w, "# TYPE grafana_webhook_telegram_rate_limited_total counter\n")
+	fmt.Fprintf(w, "grafana_webhook_telegram_rate_limited_total %d\n", l.rateLimited)
+	fmt.Fprintf(w, "# HELP grafana_webhook_telegram_throttled_total Calls delayed by the limiter.\n")
+	fmt.Fprintf(w, "# TYPE grafana_webhook_telegram_throttled_total counter\n")
+	fmt.Fprintf(w, "grafana_webhook_telegram_throttled_total %d\n", l.throttled)
+	fmt.Fprintf(w, "# HELP grafana_webhook_telegram_throttle_seconds_total Total delay by the limiter.\n")
+	fmt.Fprintf(w, "# TYPE grafana_webhook_telegram_throttle_seconds_total counter\n")
+	fmt.Fprintf(w, "grafana_webhook_telegram_throttle_seconds_total %g\n", l.throttleSec)
+	fmt.Fprintf(w, "# HELP grafana_webhook_telegram_waiting Calls waiting for the limiter now.\n")
+	fmt.Fprintf(w, "# TYPE grafana_webhook_telegram_waiting gauge\n")
+	fmt.Fprintf(w, "grafana_webhook_telegram_waiting %d\n", l.waiting)
+	fmt.Fprintf(w, "# HELP grafana_webhook_telegram_global_tokens Messages available in the global bucket.\n")
+	fmt.Fprintf(w, "# TYPE grafana_webhook_telegram_global_tokens gauge\n")
+	fmt.Fprintf(w, "grafana_webhook_telegram_global_tokens %g\n", l.global.tokens)
+	fmt.Fprintf(w, "# HELP grafana_webhook_telegram_chats Chats tracked by the limiter.\n")
+	fmt.Fprintf(w, "# TYPE grafana_webhook_telegram_chats gauge\n")
+	fmt.Fprintf(w, "grafana_webhook_telegram_chats %d\n", len(l.chats))
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := &tokenBucket_t{rate: 2, burst: 2, tokens: 2, last: now}
+
+	for i, want := range []time.Duration{0, 0, 500 * time.Millisecond, time.Second} {
+		if got := b.reserve(1, now); got != want {
+			t.Errorf("Message %d: wait %s, want %s", i+1, got, want)
+		}
+	}
+	// Refilled in 1.5s, the reserved tokens are paid back first
+	if got := b.reserve(1, now.Add(1500*time.Millisecond)); got != 0 {
+		t.Errorf("After refill: wait %s", got)
+	}
+	// Album of 4 messages
+	if got := b.reserve(4, now.Add(1500*time.Millisecond)); got != 2*time.Second {
+		t.Errorf("Album: wait %s", got)
+	}
+	b.until = now.Add(10 * time.Second)
+	if got := b.reserve(0, now.Add(2*time.Second)); got != 8*time.Second {
+		t.Errorf("Blocked by retry_after: wait %s", got)
+	}
+}
+
+func TestTelegramLimiterChats(t *testing.T) {
+	t.Setenv("TELEGRAM_RATE", "100")
+	l, err := newTelegramLimiter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Group: 20 per minute as burst, the 21st waits 3s
+	for i := 0; i < 20; i++ {
+		if wait := l.reserve(-100, 1); wait != 0 {
+			t.Fatalf("Group message %d: wait %s", i+1, wait)
+		}
+	}
+	if wait := l.reserve(-100, 1); wait < 2900*time.Millisecond || wait > 3*time.Second {
+		t.Errorf("Group message 21: wait %s", wait)
+	}
+	// Private chat: 1 per second, other chats are not affected
+	if wait := l.reserve(1, 1); wait != 0 {
+		t.Errorf("Private message 1: wait %s", wait)
+	}
+	if wait := l.reserve(1, 1); wait < 900*time.Millisecond {
+		t.Errorf("Private message 2: wait %s", wait)
+	}
+
+	t.Setenv("TELEGRAM_GROUP_RATE", "fast")
+	if _, err := newTelegramLimiter(); err == nil {
+		t.Error("Bad TELEGRAM_GROUP_RATE is accepted")
+	}
+}
+
+func TestTelegramRetryAfter(t *testing.T) {
+	app, f := testTelegramApp(t)
+	l, err := newTelegramLimiter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.limit = l
+	f.limit["sendMediaGroup"] = 1
+
+	var images []*image_t
+	for i := 0; i < 2; i++ {
+		images = append(images, &image_t{name: fmt.Sprintf("%d.png", i), contentType: "image/png", data: []byte{byte(i)}})
+	}
+	start := time.Now()
+	if _, err := app.directTelegramGroup(app.ctx, -100, "Alert", images, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < time.Second {
+		t.Errorf("Retried after %s, retry_after is 1s", d)
+	}
+	if got := strings.Join(f.methods(), ","); got != "sendMediaGroup,sendMediaGroup" {
+		t.Fatalf("Calls %s", got)
+	}
+	if f.calls[1].files != 2 {
+		t.Errorf("Retry uploaded %d files", f.calls[1].files)
+	}
+
+	var buf bytes.Buffer
+	l.writeMetrics(&buf)
+	for _, m := range []string{
+		`grafana_webhook_telegram_calls_total{result="ok"} 1`,
+		"grafana_webhook_telegram_rate_limited_total 1",
+		"grafana_webhook_telegram_chats 1",
+	} {
+		if !strings.Contains(buf.String(), m) {
+			t.Errorf("Metrics have no %q:\n%s", m, buf.String())
+		}
+	}
+
+	// retry_after over TELEGRAM_MAX_RETRY_WAIT fails at once
+	l.maxRetryWait = 0
+	f.limit["sendMessage"] = 1
+	if _, err := app.directTelegram(app.ctx, 1, "Alert", nil, false, nil); err == nil {
+		t.Error("429 over the max wait is not returned")
+	}
+}
+
+func TestTelegramLimiterDeadline(t *testing.T) {
+	app, f := testTelegramApp(t)
+	l, err := newTelegramLimiter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.limit = l
+	ctx, cancel := context.WithTimeout(app.ctx, time.Second)
+	defer cancel()
+
+	// The group burst is 20 messages, the next one waits 3s
+	for i := 0; i < 20; i++ {
+		if _, err := app.directTelegram(ctx, -100, "Alert", nil, false, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now()
+	_, err = app.directTelegram(ctx, -100, "Alert", nil, false, nil)
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 500*time.Millisecond {
+		t.Errorf("Expected deadline error at once, got %v in %s", err, time.Since(start))
+	}
+	if len(f.calls) != 20 {
+		t.Errorf("%d calls", len(f.calls))
+	}
+	// The message not sent does not hold the next ones
+	if wait := l.reserve(-100, 1); wait > 4*time.Second {
+		t.Errorf("Wait %s", wait)
+	}
+}
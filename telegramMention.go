@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log/slog"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/go-telegram/bot/models"
+)
+
+// mention_t is the Telegram user mentioned in the group messages of the alert.
+type mention_t struct {
+	name     string // shown in the message
+	userID   int64  // text_mention, direct messages
+	username string // mention by @username, no direct messages
+}
+
+// mentions returns the users to mention for the alerts: "notify_users" labels and annotations, and notifyUsers
+// of the first matching route having them. Users are separated by ',' or space: "@username", Telegram user ID,
+// or a person of the "people" section with Telegram chat ID. dm is true if the users get a direct message too:
+// "notify_dm" label or annotation is "true", or notifyDM of the route.
+func (c *config_t) mentions(alerts []*AlertBody) ([]mention_t, bool) {
+
+	var users []string
+	dm := false
+	split := func(s string) []string {
+		return strings.FieldsFunc(s, func(c rune) bool { return c == ' ' || c == ',' || c == ';' })
+	}
+	for _, alert := range alerts {
+		if alert == nil {
+			continue
+		}
+		users = append(users, split(alert.Labels["notify_users"])...)
+		if s, ok := alert.Annotations["notify_users"].(string); ok {
+			users = append(users, split(s)...)
+		}
+		if alert.Labels["notify_dm"] == "true" {
+			dm = true
+		}
+		if s, ok := alert.Annotations["notify_dm"].(string); ok && s == "true" {
+			dm = true
+		}
+		for _, r := range c.Routes {
+			if len(r.NotifyUsers) > 0 && r.matches(alert.Labels) {
+				users = append(users, r.NotifyUsers...)
+				dm = dm || r.NotifyDM
+				break
+			}
+		}
+	}
+
+	var mentions []mention_t
+	seen := make(map[string]bool)
+	for _, u := range users {
+		m, ok := c.mention(u)
+		if !ok {
+			slog.Warn("notify_users, unknown user", "user", u)
+			continue
+		}
+		key := m.username
+		if m.userID != 0 {
+			key = strconv.FormatInt(m.userID, 10)
+		}
+		if !seen[key] {
+			seen[key] = true
+			mentions = append(mentions, m)
+		}
+	}
+	return mentions, dm && len(mentions) > 0
+}
+
+func (c *config_t) mention(s string) (mention_t, bool) {
+
+	if strings.HasPrefix(s, "@") && len(s) > 1 {
+		return mention_t{name: s, username: s[1:]}, true
+	}
+	if id, err := strconv.ParseInt(s, 10, 64); err == nil && id > 0 {
+		return mention_t{name: s, userID: id}, true
+	}
+	if t, ok := c.people[s]; ok && t.kind == "telegram" {
+		if id, err := strconv.ParseInt(t.dest, 10, 64); err == nil && id > 0 {
+			return mention_t{name: s, userID: id}, true
+		}
+	}
+	return mention_t{}, false
+}
+
+// mentionText returns "CC: alice, @bob" and its entities: text_mention by user ID, mention by username.
+func mentionText(mentions []mention_t) (string, []models.MessageEntity) {
+
+	text := "CC:"
+	var entities []models.MessageEntity
+	for i, m := range mentions {
+		if i > 0 {
+			text += ","
+		}
+		text += " "
+		e := models.MessageEntity{
+			Type:   models.MessageEntityTypeMention,
+			Offset: utf16Len(text),
+			Length: utf16Len(m.name),
+		}
+		if m.userID != 0 {
+			e.Type = models.MessageEntityTypeTextMention
+			e.User = &models.User{ID: m.userID}
+		}
+		text += m.name
+		entities = append(entities, e)
+	}
+	return text, entities
+}
+
+// Entity offsets are in UTF-16 code units
+func utf16Len(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
+
+// mentionHTML returns "CC: alice, @bob" with the links to the users known by ID, for HTML parse mode.
+func mentionHTML(mentions []mention_t) string {
+
+	var names []string
+	for _, m := range mentions {
+		if m.userID != 0 {
+			names = append(names, fmt.Sprintf(`<a href="tg://user?id=%d">%s</a>`, m.userID, html.EscapeString(m.name)))
+		} else {
+			names = append(names, html.EscapeString(m.name))
+		}
+	}
+	return "CC: " + strings.Join(names, ", ")
+}
+
+// withMentions appends the mentions to the text of the message or the caption. Entities can not be used
+// with parse mode, the mentions are HTML links then, and the entities are nil.
+func (a *App) withMentions(text string, mentions []mention_t) (string, []models.MessageEntity) {
+
+	if len(mentions) == 0 {
+		return text, nil
+	}
+	text = strings.TrimRight(text, " \n")
+	if text != "" {
+		text += "\n\n"
+	}
+	if a.parseMode == models.ParseModeHTML {
+		return text + mentionHTML(mentions), nil
+	}
+	cc, entities := mentionText(mentions)
+	offset := utf16Len(text)
+	for i := range entities {
+		entities[i].Offset += offset
+	}
+	return text + cc, entities
+}
+
+// directMessages sends the notification to the mentioned users directly, if it is asked for.
+// Users known by username only can not be written to first, they are skipped. Errors are logged only.
+func (a *App) directMessages(n *notification, targets []target_t) {
+
+	if !n.dm || a.bot == nil {
+		return
+	}
+	telegram, ok := a.notifiers["telegram"]
+	if !ok {
+		return
+	}
+	for _, m := range n.mentions {
+		if m.userID == 0 {
+			slog.Warn("deliver, no direct message by username", "user", m.name)
+			continue
+		}
+		dest := strconv.FormatInt(m.userID, 10)
+		if containsTarget(targets, target_t{kind: "telegram", dest: dest}) {
+			continue
+		}
+		dm := *n
+		dm.mentions = nil
+		dm.pin = false // pinned in the group chats only
+		if err := telegram.send(&dm, dest); err != nil {
+			slog.Error("deliver, direct message error", "user", m.name, "err", err)
+			continue
+		}
+		slog.Info("deliver, direct message sent", "user", m.name)
+	}
+}
+
+func containsTarget(targets []target_t, t target_t) bool {
+	for _, tt := range targets {
+		if tt == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/go-telegram/bot/models"
+)
+
+func TestMentions(t *testing.T) {
+	c := &config_t{
+		people: map[string]target_t{"alice": {"telegram", "111"}, "carol": {"email", "carol@example.com"}},
+		Routes: []*route_t{
+			{Match: map[string]string{"team": "db"}, NotifyUsers: []string{"alice", "@dba"}, NotifyDM: true},
+		},
+	}
+	alerts := []*AlertBody{
+		{Labels: map[string]string{"team": "db", "notify_users": "@bob, 222"}},
+		{Labels: map[string]string{"team": "web"}, Annotations: map[string]interface{}{"notify_users": "alice carol"}},
+	}
+	mentions, dm := c.mentions(alerts)
+	var names []string
+	for _, m := range mentions {
+		names = append(names, m.name)
+	}
+	if strings.Join(names, ",") != "@bob,222,alice,@dba" || !dm {
+		t.Errorf("Mentions %v, dm %v", names, dm)
+	}
+	if mentions[2].userID != 111 || mentions[0].username != "bob" {
+		t.Errorf("Users %+v", mentions)
+	}
+	if _, dm := c.mentions(alerts[1:]); dm {
+		t.Error("DM is on without notifyDM")
+	}
+
+	text, entities := mentionText([]mention_t{{name: "Алиса 👩", userID: 111}, {name: "@bob", username: "bob"}})
+	if text != "CC: Алиса 👩, @bob" {
+		t.Errorf("Text %q", text)
+	}
+	if e := entities[0]; e.Type != models.MessageEntityTypeTextMention || e.Offset != 4 || e.Length != 8 || e.User.ID != 111 {
+		t.Errorf("Entity %+v", e)
+	}
+	if e := entities[1]; e.Type != models.MessageEntityTypeMention || e.Offset != 14 || e.Length != 4 {
+		t.Errorf("Entity %+v", e)
+	}
+}
+
+func TestTelegramMentions(t *testing.T) {
+	app, f := testTelegramApp(t)
+	app.notifiers = map[string]notifier{"telegram": &telegramNotifier_t{a: app}}
+
+	n := &notification{msg: "Alert", fingerprint: "fp1", mentions: []mention_t{{name: "alice", userID: 111}, {name: "@bob", username: "bob"}}, dm: true, pin: true}
+	if err := app.deliver(n, []target_t{{"telegram", "-100"}}); err != nil {
+		t.Fatal(err)
+	}
+	// Alert with the mentions pinned, the direct message to alice not pinned
+	if got := strings.Join(f.methods(), ","); got != "sendMessage,pinChatMessage,sendMessage" {
+		t.Fatalf("Calls %s", got)
+	}
+	var entities []models.MessageEntity
+	json.Unmarshal([]byte(f.calls[0].fields["entities"]), &entities)
+	if f.calls[0].fields["text"] != "Alert\n\nCC: alice, @bob" || len(entities) != 2 || entities[0].Offset != 11 ||
+		entities[0].User == nil || entities[0].User.ID != 111 || entities[1].Offset != 18 {
+		t.Errorf("Mentions %q %s", f.calls[0].fields["text"], f.calls[0].fields["entities"])
+	}
+	if f.calls[2].fields["chat_id"] != "111" || f.calls[2].fields["text"] != "Alert" || f.calls[2].fields["entities"] != "" {
+		t.Errorf("Direct message %v", f.calls[2].fields)
+	}
+
+	// Album caption, HTML links in parse mode
+	app.parseMode = models.ParseModeHTML
+	img := &image_t{name: "panel.png", contentType: "image/png", data: []byte("png")}
+	n = &notification{msg: "Alert", images: []*image_t{img, img}, mentions: n.mentions}
+	if err := app.deliver(n, []target_t{{"telegram", "-100"}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.calls[3].method; got != "sendMediaGroup" || len(f.calls) != 4 {
+		t.Fatalf("Calls %v", f.methods())
+	}
+	var media []map[string]any
+	json.Unmarshal([]byte(f.calls[3].fields["media"]), &media)
+	if caption := media[0]["caption"]; caption != "Alert\n\nCC: <a href=\"tg://user?id=111\">alice</a>, @bob" {
+		t.Errorf("Caption %q", caption)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/go-telegram/bot"
+)
+
+// pinStore_t keeps the pinned Telegram messages of the firing alerts by fingerprint and chat,
+// they are unpinned when the alert resolves.
+type pinStore_t struct {
+	mu   sync.Mutex
+	pins map[string]map[int64]int // fingerprint -> chat ID -> message ID
+}
+
+func newPinStore() *pinStore_t {
+	return &pinStore_t{pins: make(map[string]map[int64]int)}
+}
+
+// pinned records the pinned message, returns the message pinned before for the alert in the chat, 0 if none.
+func (s *pinStore_t) pinned(fingerprint string, chatID int64, messageID int) int {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chats, ok := s.pins[fingerprint]
+	if !ok {
+		chats = make(map[int64]int)
+		s.pins[fingerprint] = chats
+	}
+	old := chats[chatID]
+	chats[chatID] = messageID
+	return old
+}
+
+// take returns the pinned messages of the alert by chat and forgets them.
+func (s *pinStore_t) take(fingerprint string) map[int64]int {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chats := s.pins[fingerprint]
+	delete(s.pins, fingerprint)
+	return chats
+}
+
+// pinAlert pins the message of the alert in the chat, the message pinned before for the alert is unpinned.
+func (a *App) pinAlert(n *notification, chatID int64, messageID int) error {
+
+	if err := a.pinMessage(chatID, messageID, n.silent); err != nil {
+		return err
+	}
+	if old := a.pins.pinned(n.fingerprint, chatID, messageID); old != 0 && old != messageID {
+		if err := a.unpinMessage(chatID, old); err != nil {
+			slog.Error("telegram, unpin error", "chatID", chatID, "messageID", old, "err", err)
+		}
+	}
+	return nil
+}
+
+// unpinAlert unpins the messages of the resolved alert in all the chats. Errors are logged only.
+func (a *App) unpinAlert(fingerprint string) {
+
+	if len(fingerprint) == 0 || a.bot == nil {
+		return
+	}
+	for chatID, messageID := range a.pins.take(fingerprint) {
+		if err := a.unpinMessage(chatID, messageID); err != nil {
+			slog.Error("telegram, unpin error", "chatID", chatID, "messageID", messageID, "err", err)
+		}
+	}
+}
+
+// pinMessage pins the message in the chat. The bot needs the right to pin in groups.
+func (a *App) pinMessage(chatID int64, messageID int, silent bool) error {
+	return a.limit.do(a.ctx, chatID, 1, func() error {
+		_, err := a.bot.PinChatMessage(a.ctx, &bot.PinChatMessageParams{
+			ChatID:              chatID,
+			MessageID:           messageID,
+			DisableNotification: silent,
+		})
+		if err != nil {
+			return fmt.Errorf("pin: %w", err)
+		}
+		slog.Info("telegram, pinned", "chatID", chatID, "messageID", messageID)
+		return nil
+	})
+}
+
+func (a *App) unpinMessage(chatID int64, messageID int) error {
+	return a.limit.do(a.ctx, chatID, 1, func() error {
+		_, err := a.bot.UnpinChatMessage(a.ctx, &bot.UnpinChatMessageParams{
+			ChatID:    chatID,
+			MessageID: messageID,
+		})
+		if err != nil {
+			return fmt.Errorf("unpin: %w", err)
+		}
+		slog.Info("telegram, unpinned", "chatID", chatID, "messageID", messageID)
+		return nil
+	})
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTelegramPin(t *testing.T) {
+	app, f := testTelegramApp(t)
+	app.notifiers = map[string]notifier{"telegram": &telegramNotifier_t{a: app}}
+
+	n := &notification{fingerprint: "fp", msg: "Alert", pin: true, silent: true}
+	if err := app.deliver(n, []target_t{{"telegram", "-100"}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(f.methods(), ","); got != "sendMessage,pinChatMessage" {
+		t.Fatalf("Calls %s", got)
+	}
+	if f.calls[0].fields["disable_notification"] != "true" {
+		t.Errorf("Message is not silent %v", f.calls[0].fields)
+	}
+	if f.calls[1].fields["message_id"] != "1" || f.calls[1].fields["chat_id"] != "-100" {
+		t.Errorf("Pin %v", f.calls[1].fields)
+	}
+}
+
+func TestTelegramUnpin(t *testing.T) {
+	app, f := testTelegramApp(t)
+	app.notifiers = map[string]notifier{"telegram": &telegramNotifier_t{a: app}}
+
+	n := &notification{fingerprint: "fp", status: "firing", msg: "Alert", pin: true}
+	targets := []target_t{{"telegram", "-100"}, {"telegram", "-200"}}
+	for i := 0; i < 2; i++ { // the repeat is pinned instead of the first message
+		if err := app.deliver(n, targets[:1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := app.deliver(n, targets[1:]); err != nil {
+		t.Fatal(err)
+	}
+	app.unpinAlert("fp")
+	app.unpinAlert("fp")
+
+	want := "sendMessage,pinChatMessage,sendMessage,pinChatMessage,unpinChatMessage," +
+		"sendMessage,pinChatMessage,unpinChatMessage,unpinChatMessage"
+	if got := strings.Join(f.methods(), ","); got != want {
+		t.Fatalf("Calls %s", got)
+	}
+	if f.calls[4].fields["message_id"] != "1" {
+		t.Errorf("Repeat unpinned %v", f.calls[4].fields)
+	}
+	unpinned := map[string]string{}
+	for _, c := range f.calls[7:] {
+		unpinned[c.fields["chat_id"]] = c.fields["message_id"]
+	}
+	if unpinned["-100"] != "2" || unpinned["-200"] != "3" {
+		t.Errorf("Unpinned %v", unpinned)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Telegram limits, in characters
+const (
+	telegramMaxMessage = 4096
+	telegramMaxCaption = 1024
+)
+
+// The caption of the photo followed by the text of the message as a reply.
+const telegramShortCaption = 200
+
+// Room for the "[i/n]" line of the numbered parts.
+const telegramPartHeader = 12
+
+// splitMessage cuts the text into the messages, numbered "[i/n]" if there are several.
+func splitMessage(text string, html bool) []string {
+
+	parts := splitText(text, telegramMaxMessage, html)
+	if len(parts) == 1 {
+		return parts
+	}
+	parts = splitText(text, telegramMaxMessage-telegramPartHeader, html)
+	for i := range parts {
+		parts[i] = fmt.Sprintf("[%d/%d]\n%s", i+1, len(parts), parts[i])
+	}
+	return parts
+}
+
+// shortCaption returns the beginning of the text for the caption of the photo, "…" marks the cut.
+func shortCaption(text string, html bool) string {
+	parts := splitText(text, telegramShortCaption, html)
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	return parts[0] + "\n…"
+}
+
+// htmlTag is the tag open at the cut.
+type htmlTag struct {
+	name string
+	open string // as it is in the text, with the attributes
+}
+
+var htmlTagRe = regexp.MustCompile(`<(/?)([a-zA-Z][a-zA-Z0-9-]*)[^>]*>`)
+
+// splitText cuts the text into parts of max characters at line breaks, too long lines are cut at a space if there is one.
+// In HTML mode the tags open at the cut are closed at the end of the part and opened again at the start of the next one,
+// tags and entities are not cut.
+func splitText(text string, max int, html bool) []string {
+
+	var parts []string
+	var cur strings.Builder
+	var stack []htmlTag // open at the end of cur
+	reopened := 0       // length of the tags opened again at the start of cur
+	curLen := 0
+
+	for _, u := range textUnits(text, max/2, html) {
+		next := stack
+		if html {
+			next = applyTags(stack, u)
+		}
+		uLen := utf8.RuneCountInString(u)
+		if curLen > reopened && curLen+uLen+utf8.RuneCountInString(closeTags(next)) > max {
+			parts = append(parts, strings.TrimRight(cur.String(), "\n")+closeTags(stack))
+			cur.Reset()
+			open := openTags(stack)
+			cur.WriteString(open)
+			reopened = utf8.RuneCountInString(open)
+			curLen = reopened
+		}
+		cur.WriteString(u)
+		curLen += uLen
+		stack = next
+	}
+	if curLen > reopened || len(parts) == 0 {
+		parts = append(parts, strings.TrimRight(cur.String(), "\n")+closeTags(stack))
+	}
+	return parts
+}
+
+// textUnits splits the text into lines with their line breaks, the lines longer than size are cut.
+func textUnits(text string, size int, html bool) []string {
+
+	var units []string
+	for _, line := range strings.SplitAfter(text, "\n") {
+		for utf8.RuneCountInString(line) > size {
+			cut := len(string([]rune(line)[:size]))
+			if html {
+				// Not inside a tag or an entity
+				if i := strings.LastIndexByte(line[:cut], '<'); i > strings.LastIndexByte(line[:cut], '>') && i > 0 {
+					cut = i
+				}
+				if i := strings.LastIndexByte(line[:cut], '&'); i > strings.LastIndexByte(line[:cut], ';') && i > 0 {
+					cut = i
+				}
+			}
+			if i := strings.LastIndexByte(line[:cut], ' '); i > cut/2 {
+				cut = i + 1
+			}
+			units = append(units, line[:cut])
+			line = line[cut:]
+		}
+		if len(line) > 0 {
+			units = append(units, line)
+		}
+	}
+	return units
+}
+
+// applyTags returns the open tags after the text.
+func applyTags(stack []htmlTag, text string) []htmlTag {
+
+	stack = append([]htmlTag(nil), stack...)
+	for _, m := range htmlTagRe.FindAllStringSubmatch(text, -1) {
+		name := strings.ToLower(m[2])
+		if len(m[1]) == 0 {
+			stack = append(stack, htmlTag{name: name, open: m[0]})
+			continue
+		}
+		for i := len(stack) - 1; i >= 0; i-- {
+			if stack[i].name == name {
+				stack = append(stack[:i], stack[i+1:]...)
+				break
+			}
+		}
+	}
+	return stack
+}
+
+func closeTags(stack []htmlTag) string {
+	var s strings.Builder
+	for i := len(stack) - 1; i >= 0; i-- {
+		s.WriteString("</" + stack[i].name + ">")
+	}
+	return s.String()
+}
+
+func openTags(stack []htmlTag) string {
+	var s strings.Builder
+	for _, t := range stack {
+		s.WriteString(t.open)
+	}
+	return s.String()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitMessage(t *testing.T) {
+	if parts := splitMessage("short\n", false); len(parts) != 1 || parts[0] != "short" {
+		t.Errorf("Short message %q", parts)
+	}
+
+	text := strings.Repeat("Строка alert line\n", 500) // 9000 characters
+	parts := splitMessage(text, false)
+	if len(parts) != 3 {
+		t.Fatalf("%d parts", len(parts))
+	}
+	var joined []string
+	for i, p := range parts {
+		if n := utf8.RuneCountInString(p); n > telegramMaxMessage {
+			t.Errorf("Part %d: %d characters", i+1, n)
+		}
+		header, body, _ := strings.Cut(p, "\n")
+		if want := "[" + string(rune('1'+i)) + "/3]"; header != want {
+			t.Errorf("Part %d header %q", i+1, header)
+		}
+		if !strings.HasSuffix(body, "line") {
+			t.Errorf("Part %d is not cut at a line break", i+1)
+		}
+		joined = append(joined, body)
+	}
+	if strings.Join(joined, "\n") != strings.TrimSuffix(text, "\n") {
+		t.Error("Parts do not make the text")
+	}
+
+	// One long line is cut at spaces
+	parts = splitText(strings.Repeat("word ", 100), 100, false)
+	for i, p := range parts {
+		if utf8.RuneCountInString(p) > 100 || strings.HasPrefix(p, "ord") {
+			t.Errorf("Part %d %q", i+1, p)
+		}
+	}
+}
+
+func TestSplitHTML(t *testing.T) {
+	text := "<b>Alert</b>\n<pre>" + strings.Repeat("log line &amp; more\n", 30) + "</pre>\n<a href=\"http://grafana/d/1\">Dashboard</a>"
+	parts := splitText(text, 200, true)
+	if len(parts) < 3 {
+		t.Fatalf("%d parts", len(parts))
+	}
+	for i, p := range parts {
+		if utf8.RuneCountInString(p) > 200 {
+			t.Errorf("Part %d: %d characters", i+1, utf8.RuneCountInString(p))
+		}
+		if len(applyTags(nil, p)) != 0 {
+			t.Errorf("Part %d has unclosed tags: %q", i+1, p)
+		}
+		if strings.Count(p, "<pre>") != strings.Count(p, "</pre>") {
+			t.Errorf("Part %d is not balanced: %q", i+1, p)
+		}
+		if i > 0 && i < len(parts)-1 && !strings.HasPrefix(p, "<pre>") {
+			t.Errorf("Part %d does not reopen <pre>: %q", i+1, p)
+		}
+	}
+	if !strings.HasSuffix(parts[len(parts)-1], `<a href="http://grafana/d/1">Dashboard</a>`) {
+		t.Errorf("Last part %q", parts[len(parts)-1])
+	}
+
+	// A long line is not cut inside a tag or an entity
+	line := strings.Repeat("x", 95) + "<i>" + strings.Repeat("&amp;", 40) + "</i>"
+	for i, p := range splitText(line, 100, true) {
+		if strings.Contains(strings.ReplaceAll(p, "&amp;", ""), "&") || strings.Count(p, "<") != strings.Count(p, ">") {
+			t.Errorf("Part %d is cut inside: %q", i+1, p)
+		}
+	}
+
+	if c := shortCaption("<b>"+strings.Repeat("Firing\n", 100)+"</b>", true); !strings.HasSuffix(c, "</b>\n…") {
+		t.Errorf("Short caption %q", c)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// fakeTelegram is Telegram bot API server recording the calls.
+type fakeTelegram struct {
+	mu     sync.Mutex
+	calls  []fakeCall
+	fail   map[string]string // method -> "Bad Request" description
+	limit  map[string]int    // method -> number of 429 responses left
+	nextID int
+}
+
+type fakeCall struct {
+	method string
+	fields map[string]string
+	files  int
+}
+
+func (f *fakeTelegram) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	call := fakeCall{method: method, fields: map[string]string{}}
+	if err := r.ParseMultipartForm(32 << 20); err == nil {
+		for k, v := range r.MultipartForm.Value {
+			call.fields[k] = v[0]
+		}
+		call.files = len(r.MultipartForm.File)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, call)
+
+	if f.limit[method] > 0 {
+		f.limit[method]--
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprintf(w, `{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 1","parameters":{"retry_after":1}}`)
+		return
+	}
+	if desc, ok := f.fail[method]; ok {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"ok":false,"error_code":400,"description":%q}`, "Bad Request: "+desc)
+		return
+	}
+	message := func(photo bool) string {
+		f.nextID++
+		if photo {
+			return fmt.Sprintf(`{"message_id":%d,"date":0,"chat":{"id":1,"type":"group"},"photo":[{"file_id":"small%d"},{"file_id":"file%d"}]}`,
+				f.nextID, f.nextID, f.nextID)
+		}
+		return fmt.Sprintf(`{"message_id":%d,"date":0,"chat":{"id":1,"type":"group"}}`, f.nextID)
+	}
+	result := "true"
+	switch method {
+	case "sendMessage", "sendDocument":
+		result = message(false)
+	case "sendPhoto":
+		result = message(true)
+	case "sendMediaGroup":
+		var media []json.RawMessage
+		json.Unmarshal([]byte(call.fields["media"]), &media)
+		var msgs []string
+		for range media {
+			msgs = append(msgs, message(true))
+		}
+		result = "[" + strings.Join(msgs, ",") + "]"
+	}
+	fmt.Fprintf(w, `{"ok":true,"result":%s}`, result)
+}
+
+func (f *fakeTelegram) methods() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var methods []string
+	for _, c := range f.calls {
+		methods = append(methods, c.method)
+	}
+	return methods
+}
+
+// testTelegramApp returns App sending to the fake Telegram server.
+func testTelegramApp(t *testing.T) (*App, *fakeTelegram) {
+	f := &fakeTelegram{fail: map[string]string{}, limit: map[string]int{}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	b, err := bot.New("123:test", bot.WithServerURL(srv.URL), bot.WithSkipGetMe())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &App{ctx: context.Background(), bot: b, config: &config_t{}, images: newImageCache(nil), pins: newPinStore()}, f
+}
+
+func TestTelegramMediaGroup(t *testing.T) {
+	app, f := testTelegramApp(t)
+
+	var images []*image_t
+	for i := 0; i < 12; i++ {
+		images = append(images, &image_t{name: fmt.Sprintf("%d.png", i), contentType: "image/png", data: []byte("png")})
+	}
+	msg := strings.Repeat("Alert line\n", 150)
+	if _, err := app.directTelegramGroup(app.ctx, -100, msg, images, false, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.Join(f.methods(), ","); got != "sendMediaGroup,sendMediaGroup,sendMessage" {
+		t.Fatalf("Calls %s", got)
+	}
+	var media []map[string]any
+	json.Unmarshal([]byte(f.calls[0].fields["media"]), &media)
+	if len(media) != 10 || f.calls[0].files != 10 {
+		t.Errorf("First album: %d items, %d files", len(media), f.calls[0].files)
+	}
+	caption, _ := media[0]["caption"].(string)
+	if len([]rune(caption)) > telegramShortCaption+2 || !strings.HasPrefix(msg, strings.TrimSuffix(caption, "\n…")) {
+		t.Errorf("Caption %d characters", len([]rune(caption)))
+	}
+	if f.calls[2].fields["text"] != strings.TrimSuffix(msg, "\n") {
+		t.Errorf("Follow-up is not the full text")
+	}
+	if !strings.Contains(f.calls[2].fields["reply_parameters"], `"message_id":1`) {
+		t.Errorf("Follow-up is not a reply to the album: %s", f.calls[2].fields["reply_parameters"])
+	}
+}
+
+func TestTelegramDocumentFallback(t *testing.T) {
+	app, f := testTelegramApp(t)
+	f.fail["sendPhoto"] = "PHOTO_INVALID_DIMENSIONS"
+
+	img := &image_t{name: "panel.png", contentType: "image/png", data: []byte("png")}
+	if _, err := app.directTelegram(app.ctx, -100, "text", img, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(f.methods(), ","); got != "sendPhoto,sendDocument" {
+		t.Errorf("Calls %s", got)
+	}
+}
+
+func TestTelegramFileID(t *testing.T) {
+	app, f := testTelegramApp(t)
+
+	img := &image_t{name: "panel.png", contentType: "image/png", data: []byte("png")}
+	for i := 0; i < 2; i++ {
+		if _, err := app.directTelegram(app.ctx, -100, "text", img, false, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if f.calls[0].files != 1 {
+		t.Errorf("First photo is not uploaded")
+	}
+	if f.calls[1].files != 0 || f.calls[1].fields["photo"] != "file1" {
+		t.Errorf("Second photo is not sent by file_id: %d files, photo %q", f.calls[1].files, f.calls[1].fields["photo"])
+	}
+
+	// Rejected file_id is forgotten, the image is uploaded again
+	f.fail["sendPhoto"] = "wrong file identifier"
+	app.directTelegram(app.ctx, -100, "text", img, false, nil)
+	if app.images.fileID(img) != "" {
+		t.Errorf("Rejected file_id is kept")
+	}
+}
+
+func TestTelegramLongCaption(t *testing.T) {
+	app, f := testTelegramApp(t)
+
+	img := &image_t{name: "panel.png", contentType: "image/png", data: []byte("png")}
+	msg := strings.Repeat("Annotation line\n", 400) // over the caption and the message limits
+	if _, err := app.directTelegram(app.ctx, 1, msg, img, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(f.methods(), ","); got != "sendPhoto,sendMessage,sendMessage" {
+		t.Fatalf("Calls %s", got)
+	}
+	if caption := f.calls[0].fields["caption"]; len([]rune(caption)) > telegramShortCaption+2 {
+		t.Errorf("Caption %d characters", len([]rune(caption)))
+	}
+	if !strings.Contains(f.calls[1].fields["reply_parameters"], `"message_id":1`) {
+		t.Errorf("Text is not a reply to the photo: %s", f.calls[1].fields["reply_parameters"])
+	}
+	if !strings.HasPrefix(f.calls[1].fields["text"], "[1/2]\n") || !strings.HasPrefix(f.calls[2].fields["text"], "[2/2]\n") {
+		t.Errorf("Parts are not numbered")
+	}
+}
+
+func TestTelegramHTMLEscape(t *testing.T) {
+	app, f := testTelegramApp(t)
+	app.parseMode = models.ParseModeHTML
+	app.notifiers = map[string]notifier{"telegram": &telegramNotifier_t{a: app}}
+	app.states = newAlertStore()
+	app.maintenance = newMaintenanceStore(nil)
+	app.batches = newBatcher(app.flushBatch)
+	app.chatID = -100
+
+	body := `{"status": "firing", "alerts": [{"status": "firing", "fingerprint": "fp1",
+		"labels": {"alertname": "Disk <root>"},
+		"annotations": {"summary": "free < 10% & falling, see https://grafana/d/x?a=1&b=2"}}]}`
+	rr := httptest.NewRecorder()
+	app.Alert(rr, httptest.NewRequest("POST", "/alert", strings.NewReader(body)))
+	if rr.Code != http.StatusCreated || len(f.calls) != 1 {
+		t.Fatalf("Response %d %s, calls %v", rr.Code, rr.Body, f.methods())
+	}
+	text := f.calls[0].fields["text"]
+	if f.calls[0].fields["parse_mode"] != "HTML" || !strings.Contains(text, "Disk &lt;root&gt;") ||
+		!strings.Contains(text, "free &lt; 10% &amp; falling, see https://grafana/d/x?a=1&amp;b=2") {
+		t.Errorf("Text %q", text)
+	}
+
+	// Message of /notify is the markup of Grafana template
+	n := &notification{msg: "<b>Disk</b> full", markup: true}
+	if err := app.deliver(n, []target_t{{"telegram", "-100"}}); err != nil {
+		t.Fatal(err)
+	}
+	if text := f.calls[1].fields["text"]; text != "<b>Disk</b> full" {
+		t.Errorf("Markup text %q", text)
+	}
+}
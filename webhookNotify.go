@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// Chat platforms having incoming webhooks. Destination of the target is the webhook URL:
+// "teams:https://xxx.webhook.office.com/...", "discord:https://discord.com/api/webhooks/..."
+// Images are attached to the message, Grafana and MinIO URLs are not reachable from the outside.
+
+// Incoming webhook payload limit of MS Teams. Image is scaled down to fit the card, dropped if it can not be.
+const teamsMaxPayload = 28 * 1024
+
+// Discord embed description limit.
+const discordMaxDescription = 4096
+
+type teamsNotifier_t struct {
+	ctx    context.Context
+	client *http.Client
+}
+
+type discordNotifier_t struct {
+	ctx    context.Context
+	client *http.Client
+}
+
+func newTeamsNotifier(ctx context.Context) *teamsNotifier_t {
+	return &teamsNotifier_t{ctx: ctx, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func newDiscordNotifier(ctx context.Context) *discordNotifier_t {
+	return &discordNotifier_t{ctx: ctx, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (t *teamsNotifier_t) send(n *notification, webhookURL string) error {
+
+	color := "Default"
+	switch n.status {
+	case "firing":
+		color = "Attention"
+	case "resolved":
+		color = "Good"
+	}
+	body := []map[string]any{}
+	if len(n.subject) > 0 {
+		body = append(body, map[string]any{
+			"type": "TextBlock", "text": n.subject, "weight": "Bolder", "size": "Medium", "color": color, "wrap": true,
+		})
+	}
+	for _, line := range strings.Split(n.plainMsg(), "\n") {
+		body = append(body, map[string]any{
+			"type": "TextBlock", "text": line, "wrap": true, "spacing": "None", "fontType": "Monospace",
+		})
+	}
+	var actions []map[string]any
+	for _, l := range alertLinks(n.alert) {
+		actions = append(actions, map[string]any{"type": "Action.OpenUrl", "title": l[0], "url": l[1]})
+	}
+
+	card := func(withImage []map[string]any) map[string]any {
+		return map[string]any{
+			"type": "message",
+			"attachments": []map[string]any{{
+				"contentType": "application/vnd.microsoft.card.adaptive",
+				"content": map[string]any{
+					"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+					"type":    "AdaptiveCard",
+					"version": "1.4",
+					"body":    append(body, withImage...),
+					"actions": actions,
+					"msteams": map[string]any{"width": "Full"},
+				},
+			}},
+		}
+	}
+
+	imageCard := func(img *image_t) ([]byte, error) {
+		return json.Marshal(card([]map[string]any{{
+			"type": "Image",
+			"url":  "data:" + img.contentType + ";base64," + base64.StdEncoding.EncodeToString(img.data),
+		}}))
+	}
+
+	payload, err := json.Marshal(card(nil))
+	if err != nil {
+		return err
+	}
+	if n.image != nil {
+		withImage, err := fitTeamsCard(n.image, imageCard)
+		if err != nil {
+			slog.Warn("teams. Image does not fit the card, sending without it", "image", n.image.name, "size", len(n.image.data), "err", err)
+		} else {
+			payload = withImage
+		}
+	}
+	return postWebhook(t.ctx, t.client, webhookURL, "application/json", bytes.NewReader(payload))
+}
+
+// fitTeamsCard returns the card with the image, scaled down to teamsMaxPayload if it is too big.
+func fitTeamsCard(img *image_t, imageCard func(*image_t) ([]byte, error)) ([]byte, error) {
+
+	payload, err := imageCard(img)
+	if err != nil || len(payload) <= teamsMaxPayload {
+		return payload, err
+	}
+	text := len(payload) - base64.StdEncoding.EncodedLen(len(img.data))
+	room := teamsMaxPayload - text
+	if room <= 0 {
+		return nil, fmt.Errorf("no room for the image, the card is %d bytes", text)
+	}
+	// base64 takes 4 bytes per 3 of the image
+	fitted, err := fitImage(img, room*3/4)
+	if err != nil {
+		return nil, err
+	}
+	if payload, err = imageCard(fitted); err != nil {
+		return nil, err
+	}
+	if len(payload) > teamsMaxPayload {
+		return nil, fmt.Errorf("card is %d bytes", len(payload))
+	}
+	slog.Info("teams. Image is scaled down for the card", "image", img.name, "from", len(img.data), "to", len(fitted.data))
+	return payload, nil
+}
+
+func (d *discordNotifier_t) send(n *notification, webhookURL string) error {
+
+	color := 0x808080
+	switch n.status {
+	case "firing":
+		color = 0xE01E5A
+	case "resolved":
+		color = 0x2EB67D
+	}
+	text := n.plainMsg()
+	description := "```\n" + text + "\n```"
+	if len([]rune(description)) > discordMaxDescription {
+		r := []rune(text)
+		description = "```\n" + string(r[:discordMaxDescription-12]) + "…\n```"
+	}
+	embed := map[string]any{
+		"title":       n.subject,
+		"description": description,
+		"color":       color,
+	}
+	var fields []map[string]any
+	for _, l := range alertLinks(n.alert) {
+		fields = append(fields, map[string]any{"name": l[0], "value": "[link](" + l[1] + ")", "inline": true})
+	}
+	if len(fields) > 0 {
+		embed["fields"] = fields
+	}
+	msg := map[string]any{
+		"embeds":           []map[string]any{embed},
+		"allowed_mentions": map[string]any{"parse": []string{}},
+	}
+
+	if n.image == nil {
+		payload, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		return postWebhook(d.ctx, d.client, webhookURL, "application/json", bytes.NewReader(payload))
+	}
+
+	// Image is attached as files[0] and referenced by the embed
+	name := n.image.name
+	embed["image"] = map[string]string{"url": "attachment://" + name}
+	msg["attachments"] = []map[string]any{{"id": 0, "filename": name}}
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	if err := mw.WriteField("payload_json", string(payload)); err != nil {
+		return err
+	}
+	fw, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Disposition": {fmt.Sprintf(`form-data; name="files[0]"; filename="%s"`, name)},
+		"Content-Type":        {n.image.contentType},
+	})
+	if err != nil {
+		return err
+	}
+	fw.Write(n.image.data)
+	mw.Close()
+
+	return postWebhook(d.ctx, d.client, webhookURL, mw.FormDataContentType(), &buf)
+}
+
+func postWebhook(ctx context.Context, client *http.Client, webhookURL string, contentType string, body io.Reader) error {
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("webhook: %s %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeWebhook records the requests of the incoming webhook.
+type fakeWebhook struct {
+	mu          sync.Mutex
+	contentType string
+	body        []byte
+	form        map[string]string // multipart fields and files
+	status      int
+}
+
+func (f *fakeWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.contentType = r.Header.Get("Content-Type")
+	f.form = map[string]string{}
+	if strings.HasPrefix(f.contentType, "multipart/") {
+		r.ParseMultipartForm(32 << 20)
+		for k, v := range r.MultipartForm.Value {
+			f.form[k] = v[0]
+		}
+		for k, v := range r.MultipartForm.File {
+			f.form[k] = v[0].Filename
+		}
+	} else {
+		f.body, _ = io.ReadAll(r.Body)
+	}
+	if f.status != 0 {
+		w.WriteHeader(f.status)
+		io.WriteString(w, `{"message":"Invalid Webhook Token"}`)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func testWebhook(t *testing.T) (*fakeWebhook, string) {
+	f := &fakeWebhook{}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv.URL + "/webhook"
+}
+
+func TestTeamsCard(t *testing.T) {
+	f, u := testWebhook(t)
+	teams := newTeamsNotifier(context.Background())
+
+	img := testPNG(t, 600, 600)
+	if len(img.data) <= teamsMaxPayload {
+		t.Fatalf("Test image is small %d", len(img.data))
+	}
+	n := &notification{status: "firing", subject: "[FIRING] CPU", msg: "CPU is high\nvalue=95", image: img}
+	if err := teams.send(n, u); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.body) > teamsMaxPayload {
+		t.Errorf("Card is %d bytes", len(f.body))
+	}
+	var card struct {
+		Attachments []struct {
+			Content struct {
+				Body []map[string]any `json:"body"`
+			} `json:"content"`
+		} `json:"attachments"`
+	}
+	if err := json.Unmarshal(f.body, &card); err != nil {
+		t.Fatal(err)
+	}
+	body := card.Attachments[0].Content.Body
+	if len(body) != 4 || body[0]["text"] != "[FIRING] CPU" || body[0]["color"] != "Attention" || body[2]["text"] != "value=95" {
+		t.Errorf("Card body %v", body)
+	}
+	if url, _ := body[3]["url"].(string); body[3]["type"] != "Image" || !strings.HasPrefix(url, "data:image/") {
+		t.Errorf("Image is not in the card %v", body[3]["type"])
+	}
+
+	f.status = http.StatusBadRequest
+	if err := teams.send(n, u); err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("Error %v", err)
+	}
+}
+
+func TestDiscordMessage(t *testing.T) {
+	f, u := testWebhook(t)
+	discord := newDiscordNotifier(context.Background())
+
+	n := &notification{status: "resolved", subject: "[RESOLVED] CPU", msg: "CPU is ok"}
+	if err := discord.send(n, u); err != nil {
+		t.Fatal(err)
+	}
+	var msg struct {
+		Embeds []struct {
+			Title       string `json:"title"`
+			Description string `json:"description"`
+			Color       int    `json:"color"`
+		} `json:"embeds"`
+	}
+	if err := json.Unmarshal(f.body, &msg); err != nil || f.contentType != "application/json" {
+		t.Fatalf("Payload %s %s %v", f.contentType, f.body, err)
+	}
+	if e := msg.Embeds[0]; e.Title != "[RESOLVED] CPU" || e.Description != "```\nCPU is ok\n```" || e.Color != 0x2EB67D {
+		t.Errorf("Embed %+v", e)
+	}
+
+	n.image = &image_t{name: "panel.png", contentType: "image/png", data: []byte("png")}
+	if err := discord.send(n, u); err != nil {
+		t.Fatal(err)
+	}
+	if f.form["files[0]"] != "panel.png" || !strings.Contains(f.form["payload_json"], `"url":"attachment://panel.png"`) {
+		t.Errorf("Multipart %v", f.form)
+	}
+
+	// Telegram markup of /notify is stripped
+	n = &notification{status: "firing", subject: "[FIRING] Disk", msg: "<b>Disk</b> &lt;root&gt; full", markup: true}
+	if err := discord.send(n, u); err != nil {
+		t.Fatal(err)
+	}
+	json.Unmarshal(f.body, &msg)
+	if e := msg.Embeds[0]; e.Description != "```\nDisk <root> full\n```" {
+		t.Errorf("Embed %+v", e)
+	}
+}